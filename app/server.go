@@ -1,29 +1,33 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	util "github.com/codecrafters-io/redis-starter-go/internal"
 )
 
-type Action struct {
-	command string
-	args    []util.Value
-}
+var slaves map[*util.Client]bool = make(map[*util.Client]bool)
 
-type Transaction struct {
-	IsMulti bool
-	Execs   []Action
-}
+var MasterBuffer []util.Value
 
-var slaves map[net.Conn]bool = make(map[net.Conn]bool)
+// masterMu guards slaves, MasterBuffer and replDB, the database the
+// replication stream currently has selected. It is only held for short
+// bookkeeping, never across network I/O, since commands are queued with
+// keyspace locks held.
+var masterMu sync.Mutex
+var replDB int
 
-var MasterBuffer []util.Value
+// replWake tells the replication loop that MasterBuffer has new commands.
+var replWake = make(chan struct{}, 1)
 
 func main() {
 	// You can use print statements as follows for debugging, they'll be visible when running tests.
@@ -33,9 +37,46 @@ func main() {
 
 	port := flag.String("port", "6379", "port of server")
 	replicaof := flag.String("replicaof", "", "port of master server")
-	_ = flag.String("dir", "", "directory of redis rdb")
-	_ = flag.String("dbfilename", "", "filename of rdb file")
+	dir := flag.String("dir", "", "directory of redis rdb")
+	dbfilename := flag.String("dbfilename", "", "filename of rdb file")
+	databases := flag.Int("databases", 16, "number of logical databases")
+	maxmemory := flag.String("maxmemory", "0", "memory limit of the dataset, 0 for none")
+	maxmemoryPolicy := flag.String("maxmemory-policy", "noeviction", "how keys are evicted when maxmemory is reached")
+	maxmemorySamples := flag.String("maxmemory-samples", "5", "number of keys sampled per eviction")
+	flag.Int64Var(&util.ProtoMaxBulkLen, "proto-max-bulk-len", util.ProtoMaxBulkLen, "maximum size of a single bulk string in a request")
+	flag.Int64Var(&util.MaxMultibulkLen, "max-multibulk-len", util.MaxMultibulkLen, "maximum number of elements in a request")
 	flag.Parse()
+	if *databases < 1 {
+		fmt.Println("databases must be at least 1")
+		os.Exit(1)
+	}
+	util.SetConfig("dir", *dir)
+	util.SetConfig("dbfilename", *dbfilename)
+	util.SetConfig("databases", strconv.Itoa(*databases))
+	util.SetConfig("replicaof", *replicaof)
+	util.SetConfig("proto-max-bulk-len", strconv.FormatInt(util.ProtoMaxBulkLen, 10))
+	for name, value := range map[string]string{
+		"maxmemory":         *maxmemory,
+		"maxmemory-policy":  *maxmemoryPolicy,
+		"maxmemory-samples": *maxmemorySamples,
+	} {
+		if err := util.ConfigSet(name, value); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	util.InitDatabases(*databases)
+	if *dir != "" && *dbfilename != "" {
+		if err := util.LoadRDB(filepath.Join(*dir, *dbfilename)); err != nil {
+			fmt.Println("Failed to load RDB file:", err)
+			os.Exit(1)
+		}
+	}
+	util.PropagateHook = propagate
+	go replicationLoop()
+	if *replicaof == "" {
+		util.StartActiveExpire()
+	}
 	replicaOfArr := strings.Split(*replicaof, " ")
 	if len(replicaOfArr) > 1 {
 		go func() {
@@ -88,126 +129,89 @@ func main() {
 	}
 }
 func handleConnection(conn net.Conn) {
-	var transaction Transaction = Transaction{IsMulti: false, Execs: []Action{}}
+	defer conn.Close()
+	client := util.NewClient(conn)
+	defer client.Close()
+	defer func() {
+		masterMu.Lock()
+		delete(slaves, client)
+		masterMu.Unlock()
+	}()
 	for {
-		resp := util.NewResp(conn)
-		value, err := resp.Read()
+		// Replies are only flushed once every pipelined request received so
+		// far has been answered.
+		if client.Reader.Buffered() == 0 {
+			if err := client.Flush(); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		value, err := client.Reader.ReadCommand()
 		if err != nil {
 			fmt.Println(err)
+			if errors.Is(err, util.ErrProtocol) {
+				client.Reply(util.Error("ERR " + err.Error()))
+				client.Flush()
+			}
 			return
 		}
-		if value.Type != "array" {
+		if value.Type != util.KindArray {
 			fmt.Println("Invalid Request, expected array")
 			continue
 		}
-		command := strings.ToUpper(value.Array[0].Bulk)
-		args := value.Array[1:]
 		if len(value.Array) == 0 {
-			fmt.Println("Invalid Request, expected array with length > 0")
-		}
-		if command == "SET" {
-			MasterBuffer = append(MasterBuffer, value)
-		}
-		writer := util.NewWriter(conn)
-		if command == "MULTI" {
-			res := multi(args, &transaction)
-			writer.Write(res)
-			continue
-		} else if command == "EXEC" {
-			res := exec(args, &transaction)
-			writer.Write(res)
-			continue
-		} else if command == "DISCARD" {
-			res := discard(args, &transaction)
-			writer.Write(res)
 			continue
 		}
-		handlers, ok := util.Handlers[command]
-		if !ok {
-			fmt.Println("Invalid Command: ", command)
-			writer.Write(util.Value{Type: "string", Str: ""})
-			continue
-		}
-		if transaction.IsMulti {
-			transaction.Execs = append(transaction.Execs, Action{command: command, args: args})
-			res := util.Value{Type: "string", Str: "QUEUED"}
-			writer.Write(res)
-			continue
-		}
-		result := handlers(args)
-		writer.Write(result)
-		if command == "REPLCONF" {
-			_, ok := slaves[conn]
-			if !ok {
-				slaves[conn] = true
-			}
-		}
-		if isPropagationCommand(command) {
-			go replicate()
+		command := strings.ToUpper(value.Array[0].Bulk)
+		result := util.Call(client, value.Array)
+		client.Reply(result)
+		// A replica only joins the stream once PSYNC was answered, so the
+		// commands it is fed land after the RDB payload in its buffer.
+		if command == "PSYNC" {
+			masterMu.Lock()
+			slaves[client] = true
+			masterMu.Unlock()
 		}
 	}
 }
 
-func multi(args []util.Value, transaction *Transaction) util.Value {
-	if len(args) != 0 {
-		return util.Value{Type: "error", Str: "ERR wrong number of arguments for 'multi' command"}
+// propagate queues cmd for the replicas, preceded by a SELECT whenever it
+// targets another database than the previous one.
+func propagate(db int, cmd util.Value) {
+	masterMu.Lock()
+	defer masterMu.Unlock()
+	if db != replDB {
+		MasterBuffer = append(MasterBuffer, util.BulkArray("SELECT", strconv.Itoa(db)))
+		replDB = db
 	}
-	if !transaction.IsMulti {
-		transaction.IsMulti = true
-		return util.Value{Type: "string", Str: "OK"}
+	MasterBuffer = append(MasterBuffer, cmd)
+	select {
+	case replWake <- struct{}{}:
+	default:
 	}
-	return util.Value{Type: "error", Str: "ERR MULTI calls can not be nested"}
 }
 
-func exec(args []util.Value, transaction *Transaction) util.Value {
-	if len(args) != 0 {
-		return util.Value{Type: "error", Str: "Err"}
-	}
-	if !transaction.IsMulti {
-		return util.Value{Type: "error", Str: "ERR EXEC without MULTI"}
-	}
-	queue := transaction.Execs
-	if len(queue) == 0 {
-		transaction.IsMulti = false
-		return util.Value{Type: "array", Num: 0, Array: []util.Value{}}
+// replicationLoop sends the queued commands to the replicas. Being the only
+// writer of the replication stream keeps commands in order, and the writes
+// happen without masterMu so a slow replica never holds up propagate.
+func replicationLoop() {
+	for range replWake {
+		replicate()
 	}
-	output := []util.Value{}
-	for _, iter := range queue {
-		command := iter.command
-		args := iter.args
-		handlers := util.Handlers[command]
-		output = append(output, handlers(args))
-	}
-	transaction.IsMulti = false
-	transaction.Execs = []Action{}
-	return util.Value{Type: "array", Num: len(output), Array: output}
-}
-
-func discard(args []util.Value, transaction *Transaction) util.Value {
-	if len(args) != 0 {
-		return util.Value{Type: "error", Str: "ERR wrong number of arguments for 'discard' command"}
-	}
-	if transaction.IsMulti {
-		transaction.IsMulti = false
-		transaction.Execs = []Action{}
-		return util.Value{Type: "string", Str: "OK"}
-	}
-	return util.Value{Type: "error", Str: "ERR DISCARD without MULTI"}
-}
-
-func isPropagationCommand(command string) bool {
-	return command == "SET" || command == "DEL"
 }
 
 func replicate() {
-	for conn := range slaves {
-		for _, val := range MasterBuffer {
-
-			_, err := conn.Write(val.Marshall())
-			if err != nil {
-				fmt.Println(err.Error())
-			}
+	masterMu.Lock()
+	buffer := MasterBuffer
+	MasterBuffer = nil
+	replicas := make([]*util.Client, 0, len(slaves))
+	for slave := range slaves {
+		replicas = append(replicas, slave)
+	}
+	masterMu.Unlock()
+	for _, slave := range replicas {
+		if err := slave.Feed(buffer); err != nil {
+			fmt.Println(err.Error())
 		}
 	}
-	MasterBuffer = []util.Value{}
 }
@@ -54,13 +54,14 @@ func (aof *Aof) Read(callback func(value Value)) error {
 	resp := NewResp(aof.file)
 
 	for {
-		value, err := resp.Read()
-		if err == nil {
-			callback(value)
-		}
+		value, err := resp.ReadCommand()
 		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return err
+		}
+		callback(value)
 	}
 	return nil
 }
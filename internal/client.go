@@ -0,0 +1,249 @@
+package util
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QueuedCommand is a command accepted between MULTI and EXEC, with its
+// arguments including the command name.
+type QueuedCommand struct {
+	Cmd  *Command
+	Argv []Value
+}
+
+// Client holds the state of one connection. Handlers receive the calling
+// Client so that connection-scoped commands can inspect and change it.
+type Client struct {
+	ID              int64
+	Name            string
+	DB              int
+	Proto           int
+	User            string
+	Conn            net.Conn
+	Reader          *Resp
+	Writer          *Writer
+	CreatedAt       time.Time
+	LastInteraction time.Time
+
+	// MULTI state. Queue holds the commands to run on EXEC, and MultiDirty
+	// records that a command was rejected while queueing, which aborts EXEC.
+	Multi      bool
+	MultiDirty bool
+	Queue      []QueuedCommand
+
+	// Keys watched for optimistic locking, and the channels the client is
+	// subscribed to.
+	Watched       map[string]bool
+	Subscriptions map[string]bool
+
+	// Blocked is set while the client waits in a blocking command such as
+	// XREAD BLOCK, on the keys listed in BlockedOn.
+	Blocked   bool
+	BlockedOn []string
+
+	// keyspaceLocked is set while a command of c runs with keyspaceMu held
+	// exclusively, so that handlers which lock for themselves do not try
+	// to lock again, and blocking commands, which could never be woken up,
+	// return at once instead.
+	keyspaceLocked bool
+
+	// writeMu guards Writer, which the replication stream of a replica
+	// shares with its replies.
+	writeMu sync.Mutex
+}
+
+var nextClientID int64
+
+var clients = map[int64]*Client{}
+var clientsMu = sync.RWMutex{}
+
+// NewClient wraps conn and registers the resulting client.
+func NewClient(conn net.Conn) *Client {
+	now := time.Now()
+	c := &Client{
+		ID:              atomic.AddInt64(&nextClientID, 1),
+		Proto:           2,
+		User:            "default",
+		Conn:            conn,
+		Reader:          NewResp(conn),
+		Writer:          NewWriter(conn),
+		CreatedAt:       now,
+		LastInteraction: now,
+		Watched:         map[string]bool{},
+		Subscriptions:   map[string]bool{},
+	}
+	clientsMu.Lock()
+	clients[c.ID] = c
+	clientsMu.Unlock()
+	return c
+}
+
+// Close unregisters the client and releases its buffers. The underlying
+// connection is left to the caller.
+func (c *Client) Close() {
+	clientsMu.Lock()
+	delete(clients, c.ID)
+	clientsMu.Unlock()
+	c.writeMu.Lock()
+	c.Writer.Release()
+	c.Writer = nil
+	c.writeMu.Unlock()
+}
+
+// Reply writes v using the client's negotiated protocol version.
+func (c *Client) Reply(v Value) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.Writer.Proto = c.Proto
+	return c.Writer.Write(v)
+}
+
+// Flush sends the replies written so far.
+func (c *Client) Flush() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Writer.Flush()
+}
+
+// Feed sends commands of the replication stream to a replica. They go
+// through the buffer holding its replies, so whatever was answered before,
+// such as the RDB payload following FULLRESYNC, reaches it first.
+func (c *Client) Feed(cmds []Value) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.Writer == nil {
+		return net.ErrClosed
+	}
+	c.Writer.Proto = 2
+	for _, cmd := range cmds {
+		if err := c.Writer.Write(cmd); err != nil {
+			return err
+		}
+	}
+	return c.Writer.Flush()
+}
+
+// Call runs one request from c. Inside MULTI, commands other than the
+// transaction controls are queued instead of executed.
+func Call(c *Client, argv []Value) Value {
+	c.LastInteraction = time.Now()
+	cmd, ok := LookupCommand(argv[0].Bulk)
+	if !ok {
+		c.flagTransaction()
+		return UnknownCommandError(argv)
+	}
+	// A container command is checked against the arity of the subcommand
+	// invoked, so that handlers can rely on it.
+	if sub := cmd.subcommand(argv); !sub.CheckArity(len(argv)) {
+		c.flagTransaction()
+		return sub.ArityError()
+	}
+	// Like Redis, make room before running any command, and refuse the
+	// ones that may grow the dataset when that was not possible.
+	if !performEvictions() && cmd.Flags&FlagDenyOOM != 0 {
+		c.flagTransaction()
+		return Error("OOM command not allowed when used memory > 'maxmemory'.")
+	}
+	if c.Multi && !isTransactionControl(cmd) {
+		c.Queue = append(c.Queue, QueuedCommand{Cmd: cmd, Argv: argv})
+		return SimpleString("QUEUED")
+	}
+	defer c.lockFor(cmd, argv)()
+	reply := c.execute(cmd, argv)
+	// A streamed reply reads the keyspace as it is written, so it goes out
+	// before the locks are released. A client without a connection, as in
+	// tests, gets it collected into an ordinary array instead.
+	if reply.hasStream() {
+		if c.Writer == nil {
+			return reply.collect()
+		}
+		c.Reply(reply)
+		return Value{Type: KindSent}
+	}
+	return reply
+}
+
+// execute runs cmd and, if it is a write command that changed the keyspace,
+// hands it to the replicas. The caller holds the locks cmd needs, so
+// commands are propagated in the order they took effect, and with the
+// database selected when they ran.
+func (c *Client) execute(cmd *Command, argv []Value) Value {
+	before := dirty.Load()
+	reply := cmd.Handler(c, argv[1:])
+	if cmd.Flags&FlagWrite != 0 && dirty.Load() != before {
+		propagateCommand(c.DB, Array(argv...))
+	}
+	return reply
+}
+
+// lockFor takes the keyspace locks cmd needs and returns the function
+// releasing them. For a container command they are those of the subcommand
+// invoked. Blocking commands are left to lock for themselves, so that they
+// hold no lock while they wait.
+func (c *Client) lockFor(cmd *Command, argv []Value) func() {
+	cmd = cmd.subcommand(argv)
+	if cmd.Flags&flagKeyspace != 0 {
+		unlock := lockKeyspace()
+		c.keyspaceLocked = true
+		return func() {
+			c.keyspaceLocked = false
+			unlock()
+		}
+	}
+	if cmd.Flags&FlagBlocking != 0 {
+		return func() {}
+	}
+	indexes := cmd.KeyIndexes(argv)
+	if len(indexes) == 0 {
+		return func() {}
+	}
+	keys := make([]string, len(indexes))
+	for i, index := range indexes {
+		keys[i] = argv[index].Bulk
+	}
+	return lockKeys(c.db(), keys)
+}
+
+// lockKeys is the locking used by blocking commands, which Call does not
+// lock for. It is a no-op inside EXEC, which already holds the keyspace.
+func (c *Client) lockKeys(keys ...string) func() {
+	if c.keyspaceLocked {
+		return func() {}
+	}
+	return lockKeys(c.db(), keys)
+}
+
+// lockShard locks a single shard of the selected database, for commands
+// such as SCAN that visit the keyspace one piece at a time. Like lockKeys
+// it is a no-op inside EXEC.
+func (c *Client) lockShard(index int) func() {
+	if c.keyspaceLocked {
+		return func() {}
+	}
+	keyspaceMu.RLock()
+	sh := c.db().shards[index]
+	sh.mu.Lock()
+	return func() {
+		sh.mu.Unlock()
+		keyspaceMu.RUnlock()
+	}
+}
+
+// flagTransaction marks an open transaction as failed, so that EXEC
+// discards it instead of running the commands that were queued.
+func (c *Client) flagTransaction() {
+	if c.Multi {
+		c.MultiDirty = true
+	}
+}
+
+func isTransactionControl(cmd *Command) bool {
+	switch cmd.Name {
+	case "multi", "exec", "discard":
+		return true
+	}
+	return false
+}
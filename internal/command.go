@@ -0,0 +1,1242 @@
+package util
+
+import "strings"
+
+// CommandFlag describes how a command behaves, mirroring the flags Redis
+// reports through COMMAND INFO.
+type CommandFlag uint32
+
+const (
+	FlagWrite CommandFlag = 1 << iota
+	FlagReadonly
+	FlagDenyOOM
+	FlagAdmin
+	FlagPubsub
+	FlagNoscript
+	FlagBlocking
+	FlagLoading
+	FlagStale
+	FlagFast
+	FlagMovableKeys
+
+	// flagKeyspace marks commands that act on whole databases rather than
+	// on the keys at their key positions. Call runs them with the keyspace
+	// locked exclusively. It is not reported by COMMAND INFO.
+	flagKeyspace
+)
+
+var flagNames = []struct {
+	flag CommandFlag
+	name string
+}{
+	{FlagWrite, "write"},
+	{FlagReadonly, "readonly"},
+	{FlagDenyOOM, "denyoom"},
+	{FlagAdmin, "admin"},
+	{FlagPubsub, "pubsub"},
+	{FlagNoscript, "noscript"},
+	{FlagBlocking, "blocking"},
+	{FlagLoading, "loading"},
+	{FlagStale, "stale"},
+	{FlagFast, "fast"},
+	{FlagMovableKeys, "movablekeys"},
+}
+
+// Names lists the flags set in f in their canonical order.
+func (f CommandFlag) Names() []string {
+	names := []string{}
+	for _, fn := range flagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+		}
+	}
+	return names
+}
+
+// Command is an entry of the command table.
+//
+// Arity follows the Redis convention and counts the command name itself: a
+// positive arity is an exact argument count, a negative one is a minimum.
+// FirstKey, LastKey and Step locate the key arguments (again counting the
+// name as argument 0); a negative LastKey counts back from the end, and a
+// FirstKey of 0 means the command takes no keys at fixed positions.
+//
+// Commands whose keys cannot be found from fixed positions set
+// FlagMovableKeys and provide GetKeys, which returns the key indexes of a
+// concrete invocation.
+type Command struct {
+	Name        string
+	Handler     func(c *Client, args []Value) Value
+	Arity       int
+	Flags       CommandFlag
+	FirstKey    int
+	LastKey     int
+	Step        int
+	Categories  []string
+	GetKeys     func(argv []Value) []int
+	Subcommands []*Command
+
+	// Documentation reported by COMMAND DOCS.
+	Summary    string
+	Since      string
+	Group      string
+	Complexity string
+}
+
+// Commands maps upper-cased command names to their table entries.
+var Commands = map[string]*Command{}
+
+func init() {
+	for _, cmd := range commandTable {
+		Commands[strings.ToUpper(cmd.Name)] = cmd
+	}
+}
+
+// LookupCommand finds the table entry for a command name in any case.
+func LookupCommand(name string) (*Command, bool) {
+	cmd, ok := Commands[strings.ToUpper(name)]
+	return cmd, ok
+}
+
+// CheckArity reports whether argc, which includes the command name, is
+// acceptable for cmd.
+func (cmd *Command) CheckArity(argc int) bool {
+	if cmd.Arity >= 0 {
+		return argc == cmd.Arity
+	}
+	return argc >= -cmd.Arity
+}
+
+// KeyIndexes returns the positions of the keys in argv, which starts with the
+// command name.
+func (cmd *Command) KeyIndexes(argv []Value) []int {
+	if cmd.GetKeys != nil {
+		return cmd.GetKeys(argv)
+	}
+	indexes := []int{}
+	if cmd.FirstKey <= 0 {
+		return indexes
+	}
+	last := cmd.LastKey
+	if last < 0 {
+		last = len(argv) + last
+	}
+	for i := cmd.FirstKey; i <= last && i < len(argv); i += cmd.Step {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// subcommand returns the table entry of the subcommand argv invokes, or cmd
+// itself when it has no subcommand of that name.
+func (cmd *Command) subcommand(argv []Value) *Command {
+	if len(argv) < 2 {
+		return cmd
+	}
+	name := cmd.Name + "|" + strings.ToLower(argv[1].Bulk)
+	for _, sub := range cmd.Subcommands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return cmd
+}
+
+func (cmd *Command) Has(flag CommandFlag) bool {
+	return cmd.Flags&flag != 0
+}
+
+// ArityError is the reply sent when CheckArity fails.
+func (cmd *Command) ArityError() Value {
+	return Errorf("ERR wrong number of arguments for '%s' command", cmd.Name)
+}
+
+// UnknownCommandError is the reply for a command name missing from the
+// table. Like Redis, it quotes the arguments, truncated to 128 bytes.
+func UnknownCommandError(argv []Value) Value {
+	var args strings.Builder
+	for _, arg := range argv[1:] {
+		if args.Len() >= 128 {
+			break
+		}
+		quoted := arg.Bulk
+		if room := 128 - args.Len(); len(quoted) > room {
+			quoted = quoted[:room]
+		}
+		args.WriteString("'" + quoted + "' ")
+	}
+	name := argv[0].Bulk
+	if len(name) > 128 {
+		name = name[:128]
+	}
+	return Errorf("ERR unknown command '%s', with args beginning with: %s", name, args.String())
+}
+
+// commandTable holds every command the server understands.
+var commandTable = []*Command{
+	{
+		Name:       "ping",
+		Handler:    ping,
+		Arity:      -1,
+		Flags:      FlagFast,
+		Categories: []string{"@fast", "@connection"},
+		Summary:    "Returns the server's liveliness response.",
+		Since:      "1.0.0",
+		Group:      "connection",
+		Complexity: "O(1)",
+	},
+	{
+		Name:       "echo",
+		Handler:    echo,
+		Arity:      2,
+		Flags:      FlagFast,
+		Categories: []string{"@fast", "@connection"},
+		Summary:    "Returns the given string.",
+		Since:      "1.0.0",
+		Group:      "connection",
+		Complexity: "O(1)",
+	},
+	{
+		Name:       "hello",
+		Handler:    hello,
+		Arity:      -1,
+		Flags:      FlagNoscript | FlagLoading | FlagStale | FlagFast,
+		Categories: []string{"@fast", "@connection"},
+		Summary:    "Handshakes with the Redis server.",
+		Since:      "6.0.0",
+		Group:      "connection",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "set",
+		Handler:  set,
+		Arity:    -3,
+		Flags:    FlagWrite | FlagDenyOOM,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@slow"},
+		Summary:    "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+		Since:      "1.0.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "get",
+		Handler:  get,
+		Arity:    2,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@read", "@string", "@fast"},
+		Summary:    "Returns the string value of a key.",
+		Since:      "1.0.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "incr",
+		Handler:  incr,
+		Arity:    2,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@fast"},
+		Summary:    "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+		Since:      "1.0.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "incrby",
+		Handler:  incrby,
+		Arity:    3,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@fast"},
+		Summary:    "Increments the integer value of a key by a number. Uses 0 as initial value if the key doesn't exist.",
+		Since:      "1.0.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "decr",
+		Handler:  decr,
+		Arity:    2,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@fast"},
+		Summary:    "Decrements the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+		Since:      "1.0.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "decrby",
+		Handler:  decrby,
+		Arity:    3,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@fast"},
+		Summary:    "Decrements a number from the integer value of a key. Uses 0 as initial value if the key doesn't exist.",
+		Since:      "1.0.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "incrbyfloat",
+		Handler:  incrbyfloat,
+		Arity:    3,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@fast"},
+		Summary:    "Increment the floating point value of a key by a number. Uses 0 as initial value if the key doesn't exist.",
+		Since:      "2.6.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "hset",
+		Handler:  hset,
+		Arity:    -4,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@hash", "@fast"},
+		Summary:    "Creates or modifies the value of a field in a hash.",
+		Since:      "2.0.0",
+		Group:      "hash",
+		Complexity: "O(1) for each field/value pair added, so O(N) to add N field/value pairs when the command is called with multiple field/value pairs.",
+	},
+	{
+		Name:     "hget",
+		Handler:  hget,
+		Arity:    3,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@read", "@hash", "@fast"},
+		Summary:    "Returns the value of a field in a hash.",
+		Since:      "2.0.0",
+		Group:      "hash",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "hgetall",
+		Handler:  hgetall,
+		Arity:    2,
+		Flags:    FlagReadonly,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@read", "@hash", "@slow"},
+		Summary:    "Returns all fields and values in a hash.",
+		Since:      "2.0.0",
+		Group:      "hash",
+		Complexity: "O(N) where N is the size of the hash.",
+	},
+	{
+		Name:     "hscan",
+		Handler:  hscan,
+		Arity:    -3,
+		Flags:    FlagReadonly,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@read", "@hash", "@slow"},
+		Summary:    "Iterates over fields and values of a hash.",
+		Since:      "2.8.0",
+		Group:      "hash",
+		Complexity: "O(1) for every call. O(N) for a complete iteration, including enough command calls for the cursor to return back to 0. N is the number of elements inside the collection.",
+	},
+	{
+		Name:     "hincrby",
+		Handler:  hincrby,
+		Arity:    4,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@hash", "@fast"},
+		Summary:    "Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.",
+		Since:      "2.0.0",
+		Group:      "hash",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "hincrbyfloat",
+		Handler:  hincrbyfloat,
+		Arity:    4,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@hash", "@fast"},
+		Summary:    "Increments the floating point value of a field by a number. Uses 0 as initial value if the field doesn't exist.",
+		Since:      "2.6.0",
+		Group:      "hash",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "del",
+		Handler:  del,
+		Arity:    -2,
+		Flags:    FlagWrite,
+		FirstKey: 1, LastKey: -1, Step: 1,
+		Categories: []string{"@keyspace", "@write", "@slow"},
+		Summary:    "Deletes one or more keys.",
+		Since:      "1.0.0",
+		Group:      "generic",
+		Complexity: "O(N) where N is the number of keys that will be removed.",
+	},
+	{
+		Name:       "keys",
+		Handler:    keys,
+		Arity:      2,
+		Flags:      FlagReadonly | flagKeyspace,
+		Categories: []string{"@keyspace", "@read", "@slow", "@dangerous"},
+		Summary:    "Returns all key names that match a pattern.",
+		Since:      "1.0.0",
+		Group:      "generic",
+		Complexity: "O(N) with N being the number of keys in the database.",
+	},
+	{
+		Name:       "scan",
+		Handler:    scan,
+		Arity:      -2,
+		Flags:      FlagReadonly,
+		Categories: []string{"@keyspace", "@read", "@slow"},
+		Summary:    "Iterates over the key names in the database.",
+		Since:      "2.8.0",
+		Group:      "generic",
+		Complexity: "O(1) for every call. O(N) for a complete iteration, including enough command calls for the cursor to return back to 0. N is the number of elements inside the collection.",
+	},
+	{
+		Name:     "exists",
+		Handler:  exists,
+		Arity:    -2,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: -1, Step: 1,
+		Categories: []string{"@keyspace", "@read", "@fast"},
+		Summary:    "Determines whether one or more keys exist.",
+		Since:      "1.0.0",
+		Group:      "generic",
+		Complexity: "O(N) where N is the number of keys to check.",
+	},
+	{
+		Name:     "rename",
+		Handler:  rename,
+		Arity:    3,
+		Flags:    FlagWrite,
+		FirstKey: 1, LastKey: 2, Step: 1,
+		Categories: []string{"@keyspace", "@write", "@slow"},
+		Summary:    "Renames a key and overwrites the destination.",
+		Since:      "1.0.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "renamenx",
+		Handler:  renamenx,
+		Arity:    3,
+		Flags:    FlagWrite | FlagFast,
+		FirstKey: 1, LastKey: 2, Step: 1,
+		Categories: []string{"@keyspace", "@write", "@fast"},
+		Summary:    "Renames a key only when the target key name doesn't exist.",
+		Since:      "1.0.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "copy",
+		Handler:  copyCommand,
+		Arity:    -3,
+		Flags:    FlagWrite | FlagDenyOOM | flagKeyspace,
+		FirstKey: 1, LastKey: 2, Step: 1,
+		Categories: []string{"@keyspace", "@write", "@slow"},
+		Summary:    "Copies the value of a key to a new key.",
+		Since:      "6.2.0",
+		Group:      "generic",
+		Complexity: "O(N) worst case for collections, where N is the number of nested items. O(1) for string values.",
+	},
+	{
+		Name:     "unlink",
+		Handler:  unlink,
+		Arity:    -2,
+		Flags:    FlagWrite | FlagFast,
+		FirstKey: 1, LastKey: -1, Step: 1,
+		Categories: []string{"@keyspace", "@write", "@fast"},
+		Summary:    "Asynchronously deletes one or more keys.",
+		Since:      "4.0.0",
+		Group:      "generic",
+		Complexity: "O(1) for each key removed regardless of its size. Then the command does O(N) work in a different thread in order to reclaim memory, where N is the number of allocations the deleted objects where composed of.",
+	},
+	{
+		Name:     "touch",
+		Handler:  touch,
+		Arity:    -2,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: -1, Step: 1,
+		Categories: []string{"@keyspace", "@read", "@fast"},
+		Summary:    "Returns the number of existing keys out of those specified after updating the time they were last accessed.",
+		Since:      "3.2.1",
+		Group:      "generic",
+		Complexity: "O(N) where N is the number of keys that will be touched.",
+	},
+	{
+		Name:       "randomkey",
+		Handler:    randomkey,
+		Arity:      1,
+		Flags:      FlagReadonly | flagKeyspace,
+		Categories: []string{"@keyspace", "@read", "@slow"},
+		Summary:    "Returns a random key name from the database.",
+		Since:      "1.0.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:       "object",
+		Handler:    object,
+		Arity:      -2,
+		Categories: []string{"@slow"},
+		Summary:    "A container for object introspection commands.",
+		Since:      "2.2.3",
+		Group:      "generic",
+		Complexity: "Depends on subcommand.",
+		Subcommands: []*Command{
+			{
+				Name:     "object|encoding",
+				Arity:    3,
+				Flags:    FlagReadonly,
+				FirstKey: 2, LastKey: 2, Step: 1,
+				Categories: []string{"@keyspace", "@read", "@slow"},
+				Summary:    "Returns the internal encoding of a Redis object.",
+				Since:      "2.2.3",
+				Group:      "generic",
+				Complexity: "O(1)",
+			},
+			{
+				Name:     "object|freq",
+				Arity:    3,
+				Flags:    FlagReadonly,
+				FirstKey: 2, LastKey: 2, Step: 1,
+				Categories: []string{"@keyspace", "@read", "@slow"},
+				Summary:    "Returns the logarithmic access frequency counter of a Redis object.",
+				Since:      "4.0.0",
+				Group:      "generic",
+				Complexity: "O(1)",
+			},
+			{
+				Name:     "object|idletime",
+				Arity:    3,
+				Flags:    FlagReadonly,
+				FirstKey: 2, LastKey: 2, Step: 1,
+				Categories: []string{"@keyspace", "@read", "@slow"},
+				Summary:    "Returns the time since the last access to a Redis object.",
+				Since:      "2.2.3",
+				Group:      "generic",
+				Complexity: "O(1)",
+			},
+			{
+				Name:     "object|refcount",
+				Arity:    3,
+				Flags:    FlagReadonly,
+				FirstKey: 2, LastKey: 2, Step: 1,
+				Categories: []string{"@keyspace", "@read", "@slow"},
+				Summary:    "Returns the reference count of a value of a key.",
+				Since:      "2.2.3",
+				Group:      "generic",
+				Complexity: "O(1)",
+			},
+		},
+	},
+	{
+		Name:     "type",
+		Handler:  types,
+		Arity:    2,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@read", "@fast"},
+		Summary:    "Determines the type of value stored at a key.",
+		Since:      "1.0.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:       "select",
+		Handler:    selectDB,
+		Arity:      2,
+		Flags:      FlagLoading | FlagStale | FlagFast,
+		Categories: []string{"@keyspace", "@fast"},
+		Summary:    "Changes the selected database.",
+		Since:      "1.0.0",
+		Group:      "connection",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "move",
+		Handler:  move,
+		Arity:    3,
+		Flags:    FlagWrite | FlagFast | flagKeyspace,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@write", "@fast"},
+		Summary:    "Moves a key to another database.",
+		Since:      "1.0.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:       "swapdb",
+		Handler:    swapdb,
+		Arity:      3,
+		Flags:      FlagWrite | FlagFast | flagKeyspace,
+		Categories: []string{"@keyspace", "@write", "@fast", "@dangerous"},
+		Summary:    "Swaps two Redis databases.",
+		Since:      "4.0.0",
+		Group:      "server",
+		Complexity: "O(N) where N is the count of clients watching or blocking on keys from both databases.",
+	},
+	{
+		Name:       "flushdb",
+		Handler:    flushdb,
+		Arity:      -1,
+		Flags:      FlagWrite | flagKeyspace,
+		Categories: []string{"@keyspace", "@write", "@slow", "@dangerous"},
+		Summary:    "Removes all keys from the current database.",
+		Since:      "1.0.0",
+		Group:      "server",
+		Complexity: "O(N) where N is the number of keys in the selected database",
+	},
+	{
+		Name:       "flushall",
+		Handler:    flushall,
+		Arity:      -1,
+		Flags:      FlagWrite | flagKeyspace,
+		Categories: []string{"@keyspace", "@write", "@slow", "@dangerous"},
+		Summary:    "Removes all keys from all databases.",
+		Since:      "1.0.0",
+		Group:      "server",
+		Complexity: "O(N) where N is the total number of keys in all databases",
+	},
+	{
+		Name:       "dbsize",
+		Handler:    dbsize,
+		Arity:      1,
+		Flags:      FlagReadonly | FlagFast | flagKeyspace,
+		Categories: []string{"@keyspace", "@read", "@fast"},
+		Summary:    "Returns the number of keys in the database.",
+		Since:      "1.0.0",
+		Group:      "server",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "expire",
+		Handler:  expire,
+		Arity:    -3,
+		Flags:    FlagWrite | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@write", "@fast"},
+		Summary:    "Sets the expiration time of a key in seconds.",
+		Since:      "1.0.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "pexpire",
+		Handler:  pexpire,
+		Arity:    -3,
+		Flags:    FlagWrite | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@write", "@fast"},
+		Summary:    "Sets the expiration time of a key in milliseconds.",
+		Since:      "2.6.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "expireat",
+		Handler:  expireat,
+		Arity:    -3,
+		Flags:    FlagWrite | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@write", "@fast"},
+		Summary:    "Sets the expiration time of a key to a Unix timestamp.",
+		Since:      "1.2.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "pexpireat",
+		Handler:  pexpireat,
+		Arity:    -3,
+		Flags:    FlagWrite | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@write", "@fast"},
+		Summary:    "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+		Since:      "2.6.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "ttl",
+		Handler:  ttl,
+		Arity:    2,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@read", "@fast"},
+		Summary:    "Returns the expiration time in seconds of a key.",
+		Since:      "1.0.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "pttl",
+		Handler:  pttl,
+		Arity:    2,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@read", "@fast"},
+		Summary:    "Returns the expiration time in milliseconds of a key.",
+		Since:      "2.6.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "expiretime",
+		Handler:  expiretime,
+		Arity:    2,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@read", "@fast"},
+		Summary:    "Returns the expiration time of a key as a Unix timestamp.",
+		Since:      "7.0.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "pexpiretime",
+		Handler:  pexpiretime,
+		Arity:    2,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@read", "@fast"},
+		Summary:    "Returns the expiration time of a key as a Unix milliseconds timestamp.",
+		Since:      "7.0.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "persist",
+		Handler:  persist,
+		Arity:    2,
+		Flags:    FlagWrite | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@write", "@fast"},
+		Summary:    "Removes the expiration time of a key.",
+		Since:      "2.2.0",
+		Group:      "generic",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "append",
+		Handler:  appendCommand,
+		Arity:    3,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@fast"},
+		Summary:    "Appends a string to the value of a key. Creates the key if it doesn't exist.",
+		Since:      "2.0.0",
+		Group:      "string",
+		Complexity: "O(1). The amortized time complexity is O(1) assuming the appended value is small and the already present value is of any size, since the dynamic string library used by Redis will double the free space available on every reallocation.",
+	},
+	{
+		Name:     "strlen",
+		Handler:  strlen,
+		Arity:    2,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@read", "@string", "@fast"},
+		Summary:    "Returns the length of a string value.",
+		Since:      "2.2.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "getrange",
+		Handler:  getrange,
+		Arity:    4,
+		Flags:    FlagReadonly,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@read", "@string", "@slow"},
+		Summary:    "Returns a substring of the string stored at a key.",
+		Since:      "2.4.0",
+		Group:      "string",
+		Complexity: "O(N) where N is the length of the returned string. The complexity is ultimately determined by the returned length, but because creating a substring from an existing string is very cheap, it can be considered O(1) for small strings.",
+	},
+	{
+		Name:     "setrange",
+		Handler:  setrange,
+		Arity:    4,
+		Flags:    FlagWrite | FlagDenyOOM,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@slow"},
+		Summary:    "Overwrites a part of a string value with another by an offset. Creates the key if it doesn't exist.",
+		Since:      "2.2.0",
+		Group:      "string",
+		Complexity: "O(1), not counting the time taken to copy the new string in place. Usually, this string is very small so the amortized complexity is O(1). Otherwise, complexity is O(M) with M being the length of the value argument.",
+	},
+	{
+		Name:     "mget",
+		Handler:  mget,
+		Arity:    -2,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: -1, Step: 1,
+		Categories: []string{"@read", "@string", "@fast"},
+		Summary:    "Atomically returns the string values of one or more keys.",
+		Since:      "1.0.0",
+		Group:      "string",
+		Complexity: "O(N) where N is the number of keys to retrieve.",
+	},
+	{
+		Name:     "mset",
+		Handler:  mset,
+		Arity:    -3,
+		Flags:    FlagWrite | FlagDenyOOM,
+		FirstKey: 1, LastKey: -1, Step: 2,
+		Categories: []string{"@write", "@string", "@slow"},
+		Summary:    "Atomically creates or modifies the string values of one or more keys.",
+		Since:      "1.0.1",
+		Group:      "string",
+		Complexity: "O(N) where N is the number of keys to set.",
+	},
+	{
+		Name:     "msetnx",
+		Handler:  msetnx,
+		Arity:    -3,
+		Flags:    FlagWrite | FlagDenyOOM,
+		FirstKey: 1, LastKey: -1, Step: 2,
+		Categories: []string{"@write", "@string", "@slow"},
+		Summary:    "Atomically modifies the string values of one or more keys only when all keys don't exist.",
+		Since:      "1.0.1",
+		Group:      "string",
+		Complexity: "O(N) where N is the number of keys to set.",
+	},
+	{
+		Name:     "getdel",
+		Handler:  getdel,
+		Arity:    2,
+		Flags:    FlagWrite | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@fast"},
+		Summary:    "Returns the string value of a key after deleting the key.",
+		Since:      "6.2.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "getex",
+		Handler:  getex,
+		Arity:    -2,
+		Flags:    FlagWrite | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@fast"},
+		Summary:    "Returns the string value of a key after setting its expiration time.",
+		Since:      "6.2.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "setnx",
+		Handler:  setnx,
+		Arity:    3,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@fast"},
+		Summary:    "Set the string value of a key only when the key doesn't exist.",
+		Since:      "1.0.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "setex",
+		Handler:  setex,
+		Arity:    4,
+		Flags:    FlagWrite | FlagDenyOOM,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@string", "@slow"},
+		Summary:    "Sets the string value and expiration time of a key. Creates the key if it doesn't exist.",
+		Since:      "2.0.0",
+		Group:      "string",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "lpush",
+		Handler:  lpush,
+		Arity:    -3,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@list", "@fast"},
+		Summary:    "Prepends one or more elements to a list. Creates the key if it doesn't exist.",
+		Since:      "1.0.0",
+		Group:      "list",
+		Complexity: "O(1) for each element added, so O(N) to add N elements when the command is called with multiple arguments.",
+	},
+	{
+		Name:     "rpush",
+		Handler:  rpush,
+		Arity:    -3,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@list", "@fast"},
+		Summary:    "Appends one or more elements to a list. Creates the key if it doesn't exist.",
+		Since:      "1.0.0",
+		Group:      "list",
+		Complexity: "O(1) for each element added, so O(N) to add N elements when the command is called with multiple arguments.",
+	},
+	{
+		Name:     "lpop",
+		Handler:  lpop,
+		Arity:    -2,
+		Flags:    FlagWrite | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@list", "@fast"},
+		Summary:    "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.",
+		Since:      "1.0.0",
+		Group:      "list",
+		Complexity: "O(N) where N is the number of elements returned",
+	},
+	{
+		Name:     "rpop",
+		Handler:  rpop,
+		Arity:    -2,
+		Flags:    FlagWrite | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@list", "@fast"},
+		Summary:    "Returns and removes the last elements of a list. Deletes the list if the last element was popped.",
+		Since:      "1.0.0",
+		Group:      "list",
+		Complexity: "O(N) where N is the number of elements returned",
+	},
+	{
+		Name:     "lrange",
+		Handler:  lrange,
+		Arity:    4,
+		Flags:    FlagReadonly,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@read", "@list", "@slow"},
+		Summary:    "Returns a range of elements from a list.",
+		Since:      "1.0.0",
+		Group:      "list",
+		Complexity: "O(S+N) where S is the distance of start offset from HEAD for small lists, from nearest end (HEAD or TAIL) for large lists; and N is the number of elements in the specified range.",
+	},
+	{
+		Name:     "llen",
+		Handler:  llen,
+		Arity:    2,
+		Flags:    FlagReadonly | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@read", "@list", "@fast"},
+		Summary:    "Returns the length of a list.",
+		Since:      "1.0.0",
+		Group:      "list",
+		Complexity: "O(1)",
+	},
+	{
+		Name:     "lindex",
+		Handler:  lindex,
+		Arity:    3,
+		Flags:    FlagReadonly,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@read", "@list", "@slow"},
+		Summary:    "Returns an element from a list by its index.",
+		Since:      "1.0.0",
+		Group:      "list",
+		Complexity: "O(N) where N is the number of elements to traverse to get to the element at index. This makes asking for the first or the last element of the list O(1).",
+	},
+	{
+		Name:     "lset",
+		Handler:  lset,
+		Arity:    4,
+		Flags:    FlagWrite | FlagDenyOOM,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@list", "@slow"},
+		Summary:    "Sets the value of an element in a list by its index.",
+		Since:      "1.0.0",
+		Group:      "list",
+		Complexity: "O(N) where N is the length of the list. Setting either the first or the last element of the list is O(1).",
+	},
+	{
+		Name:     "linsert",
+		Handler:  linsert,
+		Arity:    5,
+		Flags:    FlagWrite | FlagDenyOOM,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@list", "@slow"},
+		Summary:    "Inserts an element before or after another element in a list.",
+		Since:      "2.2.0",
+		Group:      "list",
+		Complexity: "O(N) where N is the number of elements to traverse before seeing the value pivot. This means that inserting somewhere on the left end on the list (head) can be considered O(1) and inserting somewhere on the right end (tail) is O(N).",
+	},
+	{
+		Name:     "lrem",
+		Handler:  lrem,
+		Arity:    4,
+		Flags:    FlagWrite,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@list", "@slow"},
+		Summary:    "Removes elements from a list. Deletes the list if the last element was removed.",
+		Since:      "1.0.0",
+		Group:      "list",
+		Complexity: "O(N+M) where N is the length of the list and M is the number of elements removed.",
+	},
+	{
+		Name:     "ltrim",
+		Handler:  ltrim,
+		Arity:    4,
+		Flags:    FlagWrite,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@list", "@slow"},
+		Summary:    "Removes elements from both ends a list. Deletes the list if all elements were trimmed.",
+		Since:      "1.0.0",
+		Group:      "list",
+		Complexity: "O(N) where N is the number of elements to be removed by the operation.",
+	},
+	{
+		Name:     "lpos",
+		Handler:  lpos,
+		Arity:    -3,
+		Flags:    FlagReadonly,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@read", "@list", "@slow"},
+		Summary:    "Returns the index of matching elements in a list.",
+		Since:      "6.0.6",
+		Group:      "list",
+		Complexity: "O(N) where N is the number of elements in the list, for the average case. When searching for elements near the head or the tail of the list, or when the MAXLEN option is provided, the command may run in constant time.",
+	},
+	{
+		Name:     "xadd",
+		Handler:  xadd,
+		Arity:    -5,
+		Flags:    FlagWrite | FlagDenyOOM | FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@write", "@stream", "@fast"},
+		Summary:    "Appends a new message to a stream. Creates the key if it doesn't exist.",
+		Since:      "5.0.0",
+		Group:      "stream",
+		Complexity: "O(1) when adding a new entry.",
+	},
+	{
+		Name:     "xrange",
+		Handler:  xrange,
+		Arity:    -4,
+		Flags:    FlagReadonly,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@read", "@stream", "@slow"},
+		Summary:    "Returns the messages from a stream within a range of IDs.",
+		Since:      "5.0.0",
+		Group:      "stream",
+		Complexity: "O(N) with N being the number of elements being returned.",
+	},
+	{
+		Name:       "xread",
+		Handler:    xread,
+		Arity:      -4,
+		Flags:      FlagReadonly | FlagBlocking | FlagMovableKeys,
+		Categories: []string{"@read", "@stream", "@slow", "@blocking"},
+		Summary:    "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise.",
+		Since:      "5.0.0",
+		Group:      "stream",
+		Complexity: "O(N) with N being the number of elements being returned.",
+		GetKeys:    xreadKeys,
+	},
+	{
+		Name:       "command",
+		Handler:    command,
+		Arity:      -1,
+		Flags:      FlagLoading | FlagStale,
+		Categories: []string{"@slow", "@connection"},
+		Summary:    "Returns detailed information about all commands.",
+		Since:      "2.8.13",
+		Group:      "server",
+		Complexity: "O(N) where N is the total number of Redis commands",
+		Subcommands: []*Command{
+			{
+				Name:       "command|count",
+				Arity:      2,
+				Flags:      FlagLoading | FlagStale,
+				Categories: []string{"@slow", "@connection"},
+				Summary:    "Returns a count of commands.",
+				Since:      "2.8.13",
+				Group:      "server",
+				Complexity: "O(1)",
+			},
+			{
+				Name:       "command|docs",
+				Arity:      -2,
+				Flags:      FlagLoading | FlagStale,
+				Categories: []string{"@slow", "@connection"},
+				Summary:    "Returns documentary information about one, multiple or all commands.",
+				Since:      "7.0.0",
+				Group:      "server",
+				Complexity: "O(N) where N is the number of commands to look up",
+			},
+			{
+				Name:       "command|getkeys",
+				Arity:      -3,
+				Flags:      FlagLoading | FlagStale,
+				Categories: []string{"@slow", "@connection"},
+				Summary:    "Extracts the key names from an arbitrary command.",
+				Since:      "2.8.13",
+				Group:      "server",
+				Complexity: "O(N) where N is the number of arguments to the command",
+			},
+			{
+				Name:       "command|info",
+				Arity:      -2,
+				Flags:      FlagLoading | FlagStale,
+				Categories: []string{"@slow", "@connection"},
+				Summary:    "Returns information about one, multiple or all commands.",
+				Since:      "2.8.13",
+				Group:      "server",
+				Complexity: "O(N) where N is the number of commands to look up",
+			},
+			{
+				Name:       "command|list",
+				Arity:      -2,
+				Flags:      FlagLoading | FlagStale,
+				Categories: []string{"@slow", "@connection"},
+				Summary:    "Returns a list of command names.",
+				Since:      "7.0.0",
+				Group:      "server",
+				Complexity: "O(N) where N is the total number of Redis commands",
+			},
+		},
+	},
+	{
+		Name:       "config",
+		Handler:    config,
+		Arity:      -2,
+		Flags:      FlagAdmin | FlagNoscript | FlagLoading | FlagStale,
+		Categories: []string{"@admin", "@slow", "@dangerous"},
+		Summary:    "A container for server configuration commands.",
+		Since:      "2.0.0",
+		Group:      "server",
+		Complexity: "Depends on subcommand.",
+		Subcommands: []*Command{
+			{
+				Name:       "config|get",
+				Arity:      -3,
+				Flags:      FlagAdmin | FlagNoscript | FlagLoading | FlagStale,
+				Categories: []string{"@admin", "@slow", "@dangerous"},
+				Summary:    "Returns the effective values of configuration parameters.",
+				Since:      "2.0.0",
+				Group:      "server",
+				Complexity: "O(N) when N is the number of configuration parameters provided",
+			},
+			{
+				Name:       "config|set",
+				Arity:      -4,
+				Flags:      FlagAdmin | FlagNoscript | FlagLoading | FlagStale,
+				Categories: []string{"@admin", "@slow", "@dangerous"},
+				Summary:    "Sets configuration parameters in-flight.",
+				Since:      "2.0.0",
+				Group:      "server",
+				Complexity: "O(N) when N is the number of configuration parameters provided",
+			},
+		},
+	},
+	{
+		Name:       "memory",
+		Handler:    memory,
+		Arity:      -2,
+		Categories: []string{"@slow"},
+		Summary:    "A container for memory diagnostics commands.",
+		Since:      "4.0.0",
+		Group:      "server",
+		Complexity: "Depends on subcommand.",
+		Subcommands: []*Command{
+			{
+				Name:       "memory|doctor",
+				Arity:      2,
+				Categories: []string{"@slow"},
+				Summary:    "Outputs a memory problems report.",
+				Since:      "4.0.0",
+				Group:      "server",
+				Complexity: "O(1)",
+			},
+			{
+				Name:       "memory|stats",
+				Arity:      2,
+				Flags:      flagKeyspace,
+				Categories: []string{"@slow"},
+				Summary:    "Returns details about memory usage.",
+				Since:      "4.0.0",
+				Group:      "server",
+				Complexity: "O(1)",
+			},
+			{
+				Name:     "memory|usage",
+				Arity:    -3,
+				Flags:    FlagReadonly,
+				FirstKey: 2, LastKey: 2, Step: 1,
+				Categories: []string{"@read", "@slow"},
+				Summary:    "Estimates the memory usage of a key.",
+				Since:      "4.0.0",
+				Group:      "server",
+				Complexity: "O(N) where N is the number of samples.",
+			},
+		},
+	},
+	{
+		Name:       "info",
+		Handler:    info,
+		Arity:      -1,
+		Flags:      FlagLoading | FlagStale,
+		Categories: []string{"@slow", "@dangerous"},
+		Summary:    "Returns information and statistics about the server.",
+		Since:      "1.0.0",
+		Group:      "server",
+		Complexity: "O(1)",
+	},
+	{
+		Name:       "replconf",
+		Handler:    replconf,
+		Arity:      -1,
+		Flags:      FlagAdmin | FlagNoscript | FlagLoading | FlagStale,
+		Categories: []string{"@admin", "@slow", "@dangerous"},
+		Summary:    "An internal command for configuring the replication stream.",
+		Since:      "3.0.0",
+		Group:      "server",
+		Complexity: "O(1)",
+	},
+	{
+		Name:       "psync",
+		Handler:    psync,
+		Arity:      -3,
+		Flags:      FlagAdmin | FlagNoscript,
+		Categories: []string{"@admin", "@slow", "@dangerous"},
+		Summary:    "An internal command used in replication.",
+		Since:      "2.8.0",
+		Group:      "server",
+		Complexity: "O(1)",
+	},
+	{
+		Name:       "multi",
+		Handler:    multi,
+		Arity:      1,
+		Flags:      FlagNoscript | FlagLoading | FlagStale | FlagFast,
+		Categories: []string{"@fast", "@transaction"},
+		Summary:    "Starts a transaction.",
+		Since:      "1.2.0",
+		Group:      "transactions",
+		Complexity: "O(1)",
+	},
+	{
+		Name:       "exec",
+		Handler:    exec,
+		Arity:      1,
+		Flags:      FlagNoscript | FlagLoading | FlagStale | flagKeyspace,
+		Categories: []string{"@slow", "@transaction"},
+		Summary:    "Executes all commands in a transaction.",
+		Since:      "1.2.0",
+		Group:      "transactions",
+		Complexity: "Depends on commands in the transaction",
+	},
+	{
+		Name:       "discard",
+		Handler:    discard,
+		Arity:      1,
+		Flags:      FlagNoscript | FlagLoading | FlagStale | FlagFast,
+		Categories: []string{"@fast", "@transaction"},
+		Summary:    "Discards a transaction.",
+		Since:      "2.0.0",
+		Group:      "transactions",
+		Complexity: "O(N), when N is the number of queued commands",
+	},
+}
@@ -0,0 +1,32 @@
+package util
+
+import "sync"
+
+// configParams holds the parameters reported by CONFIG GET. They are set
+// from command line flags at startup, and the runtime ones by CONFIG SET.
+var configParams = map[string]string{
+	"dir":                       "",
+	"dbfilename":                "",
+	"databases":                 "16",
+	"replicaof":                 "",
+	"maxmemory":                 "0",
+	"maxmemory-policy":          "noeviction",
+	"maxmemory-samples":         "5",
+	"hash-max-listpack-entries": "128",
+	"hash-max-listpack-value":   "64",
+	"list-max-listpack-size":    "-2",
+}
+var configMu = sync.RWMutex{}
+
+func SetConfig(name, value string) {
+	configMu.Lock()
+	configParams[name] = value
+	configMu.Unlock()
+}
+
+func GetConfig(name string) (string, bool) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	value, ok := configParams[name]
+	return value, ok
+}
@@ -2,10 +2,11 @@ package util
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
-	"time"
+	"strings"
 )
 
 const (
@@ -21,27 +22,45 @@ const (
 	BULKERROR      = '!'
 	MAP            = '%'
 	SET            = '~'
+	VERBATIM       = '='
 	CARRIAGERETURN = '\r'
 	LINEFEED       = '\n'
 )
 
-type Value struct {
-	Type  string  // Datatype
-	Str   string  // Simple String
-	Num   int     // Integer
-	Bulk  string  // Bulk String
-	Array []Value // Arrays
-	TTL   time.Time
-}
+var ErrProtocol = errors.New("Protocol error")
+
+// Limits applied to incoming frames, mirroring Redis' proto-max-bulk-len and
+// its hard cap on multibulk lengths. Frames exceeding them are rejected with
+// ErrProtocol before any memory is allocated for their payload.
+var (
+	ProtoMaxBulkLen int64 = 512 * 1024 * 1024
+	MaxMultibulkLen int64 = 1024 * 1024
+	MaxInlineLen    int   = 64 * 1024
+)
+
+// maxNestingDepth bounds how deeply aggregates may nest in a reply, so that
+// a hostile frame cannot exhaust the stack. Requests may not nest at all.
+const maxNestingDepth = 16
+
+// preallocLimit bounds up-front allocations, so that a header announcing a
+// large frame costs memory only as its payload actually arrives.
+const preallocLimit = 64 * 1024
 
 type Resp struct {
 	reader *bufio.Reader
+	depth  int
 }
 
 func NewResp(rd io.Reader) *Resp {
 	return &Resp{reader: bufio.NewReader(rd)}
 }
 
+// Buffered returns the number of bytes already received but not yet parsed,
+// which is non-zero while a pipeline is being drained.
+func (r *Resp) Buffered() int {
+	return r.reader.Buffered()
+}
+
 func (r *Resp) readLine() (line []byte, n int, err error) {
 	for {
 		b, err := r.reader.ReadByte()
@@ -50,6 +69,9 @@ func (r *Resp) readLine() (line []byte, n int, err error) {
 		}
 		n += 1
 		line = append(line, b)
+		if len(line) > MaxInlineLen {
+			return nil, 0, fmt.Errorf("%w: too big line", ErrProtocol)
+		}
 		if len(line) >= 2 && line[len(line)-2] == '\r' {
 			break
 		}
@@ -64,7 +86,7 @@ func (r *Resp) readInteger() (x int, n int, err error) {
 	}
 	size, err := strconv.Atoi(string(line))
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, fmt.Errorf("%w: invalid length %q", ErrProtocol, line)
 	}
 	return size, n, nil
 }
@@ -80,42 +102,355 @@ func (r *Resp) Read() (Value, error) {
 		return r.readArray()
 	case BULK:
 		return r.readBulk()
+	case STRING:
+		return r.readSimple(KindString)
+	case ERROR:
+		return r.readSimple(KindError)
+	case INTEGER:
+		return r.readIntegerValue()
+	case NULL:
+		return r.readNull()
+	case BOOLEAN:
+		return r.readBoolean()
+	case DOUBLE:
+		return r.readDouble()
+	case BIGNUM:
+		return r.readSimple(KindBignum)
+	case BULKERROR:
+		return r.readBlob(KindBulkError)
+	case VERBATIM:
+		return r.readVerbatim()
+	case MAP:
+		return r.readAggregate(KindMap, 2)
+	case SET:
+		return r.readAggregate(KindSet, 1)
 	default:
-		fmt.Printf("Unknown type: %v", string(_type))
-		return Value{}, nil
+		return Value{}, fmt.Errorf("%w: unexpected type byte %q", ErrProtocol, _type)
+	}
+}
+
+func (r *Resp) readSimple(kind Kind) (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
 	}
+	return Value{Type: kind, Str: string(line)}, nil
+}
+
+func (r *Resp) readIntegerValue() (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+	num, err := strconv.ParseInt(string(line), 10, 64)
+	if err != nil {
+		return Value{}, fmt.Errorf("%w: invalid integer %q", ErrProtocol, line)
+	}
+	return Int(num), nil
+}
+
+func (r *Resp) readDouble() (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+	f, err := strconv.ParseFloat(string(line), 64)
+	if err != nil {
+		return Value{}, fmt.Errorf("%w: invalid double %q", ErrProtocol, line)
+	}
+	return Double(f), nil
 }
 
 func (r *Resp) readArray() (Value, error) {
+	return r.readAggregate(KindArray, 1)
+}
+
+func (r *Resp) readBulk() (Value, error) {
 	v := Value{}
-	v.Type = "array"
+	v.Type = KindBulk
+	len, _, err := r.readInteger()
+	if err != nil {
+		return v, err
+	}
+	if len == -1 {
+		return NullBulk(), nil
+	}
+	if len < 0 || int64(len) > ProtoMaxBulkLen {
+		return v, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+	}
+	if len <= preallocLimit {
+		bulk := make([]byte, len)
+		if _, err := io.ReadFull(r.reader, bulk); err != nil {
+			return v, err
+		}
+		v.Bulk = string(bulk)
+	} else {
+		var bulk strings.Builder
+		bulk.Grow(preallocLimit)
+		if _, err := io.CopyN(&bulk, r.reader, int64(len)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return v, err
+		}
+		v.Bulk = bulk.String()
+	}
+	// The payload is binary safe, so the terminator is read by length rather
+	// than by scanning for "\r\n".
+	var crlf [2]byte
+	if _, err := io.ReadFull(r.reader, crlf[:]); err != nil {
+		return v, err
+	}
+	if crlf[0] != CARRIAGERETURN || crlf[1] != LINEFEED {
+		return v, fmt.Errorf("%w: expected '\\r\\n' after bulk string", ErrProtocol)
+	}
+	return v, nil
+}
+
+func (r *Resp) readNull() (Value, error) {
+	if _, _, err := r.readLine(); err != nil {
+		return Value{}, err
+	}
+	return NullBulk(), nil
+}
+
+func (r *Resp) readBoolean() (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+	if string(line) != "t" && string(line) != "f" {
+		return Value{}, fmt.Errorf("%w: invalid boolean %q", ErrProtocol, line)
+	}
+	return Bool(string(line) == "t"), nil
+}
+
+// readBlob reads a length-prefixed payload into Bulk, as used by RESP3
+// bulk errors and verbatim strings.
+func (r *Resp) readBlob(kind Kind) (Value, error) {
+	v, err := r.readBulk()
+	if err != nil || v.Type == KindNullBulk {
+		return v, err
+	}
+	v.Type = kind
+	return v, nil
+}
+
+func (r *Resp) readVerbatim() (Value, error) {
+	v, err := r.readBlob(KindVerbatim)
+	if err != nil {
+		return v, err
+	}
+	if len(v.Bulk) < 4 || v.Bulk[3] != ':' {
+		return Value{}, fmt.Errorf("%w: invalid verbatim string", ErrProtocol)
+	}
+	v.Str = v.Bulk[:3]
+	v.Bulk = v.Bulk[4:]
+	return v, nil
+}
+
+// readAggregate reads an array, map or set header followed by its elements.
+// Maps are flattened into Array as interleaved keys and values.
+func (r *Resp) readAggregate(kind Kind, width int) (Value, error) {
+	v := Value{}
+	v.Type = kind
 	length, _, err := r.readInteger()
 	if err != nil {
 		return v, err
 	}
-	v.Num = length
-	v.Array = make([]Value, length)
+	if length == -1 && kind == KindArray {
+		return NullArray(), nil
+	}
+	if length < 0 || int64(length) > MaxMultibulkLen {
+		return v, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+	if r.depth == maxNestingDepth {
+		return v, fmt.Errorf("%w: aggregates nested too deeply", ErrProtocol)
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+	length *= width
+	v.Array = make([]Value, 0, min(length, preallocLimit))
 	for i := 0; i < length; i++ {
 		val, err := r.Read()
 		if err != nil {
 			return v, err
 		}
-		v.Array[i] = val
+		v.Array = append(v.Array, val)
 	}
 	return v, nil
 }
 
-func (r *Resp) readBulk() (Value, error) {
-	v := Value{}
-	v.Type = "bulk"
-	len, _, err := r.readInteger()
+// ReadCommand reads the next client request. Requests are normally RESP
+// arrays of bulk strings, but like Redis we also accept inline commands: a
+// single line of whitespace separated arguments, as typed into telnet or
+// netcat.
+func (r *Resp) ReadCommand() (Value, error) {
+	prefix, err := r.reader.Peek(1)
 	if err != nil {
-		return v, err
+		return Value{}, err
 	}
-	bulk := make([]byte, len)
-	r.reader.Read(bulk)
-	v.Num = len
-	v.Bulk = string(bulk)
-	r.readLine()
-	return v, nil
+	if prefix[0] == ARRAY {
+		r.reader.ReadByte()
+		return r.readMultibulk()
+	}
+	line, err := r.readInline()
+	if err != nil {
+		return Value{}, err
+	}
+	args, err := splitArgs(line)
+	if err != nil {
+		return Value{}, err
+	}
+	return BulkArray(args...), nil
+}
+
+// readMultibulk reads a request array. As in Redis its elements must all be
+// bulk strings, which also keeps requests from nesting. An empty or null
+// array is returned as an empty request.
+func (r *Resp) readMultibulk() (Value, error) {
+	length, _, err := r.readInteger()
+	if err != nil {
+		return Value{}, err
+	}
+	if int64(length) > MaxMultibulkLen {
+		return Value{}, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+	args := make([]Value, 0, min(max(length, 0), preallocLimit))
+	for i := 0; i < length; i++ {
+		prefix, err := r.reader.ReadByte()
+		if err != nil {
+			return Value{}, err
+		}
+		if prefix != BULK {
+			return Value{}, fmt.Errorf("%w: expected '$', got '%c'", ErrProtocol, prefix)
+		}
+		arg, err := r.readBulk()
+		if err != nil {
+			return Value{}, err
+		}
+		if arg.Type == KindNullBulk {
+			return Value{}, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+		}
+		args = append(args, arg)
+	}
+	return Array(args...), nil
+}
+
+func (r *Resp) readInline() (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.reader.ReadSlice(LINEFEED)
+		line = append(line, chunk...)
+		if len(line) > MaxInlineLen {
+			return "", fmt.Errorf("%w: too big inline request", ErrProtocol)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+	}
+}
+
+// splitArgs tokenizes an inline command following the rules of Redis'
+// sdssplitargs: arguments are separated by whitespace and may be wrapped in
+// double quotes (supporting \n, \r, \t, \b, \a, \\, \" and \xHH escapes) or
+// single quotes (supporting only \').
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+		var current []byte
+		inDouble, inSingle, done := false, false, false
+		for !done {
+			switch {
+			case inDouble:
+				if i >= len(line) {
+					return nil, fmt.Errorf("%w: unbalanced quotes in request", ErrProtocol)
+				}
+				c := line[i]
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current = append(current, byte(b))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, line[i])
+					}
+				} else if c == '"' {
+					// The closing quote must be followed by a space or nothing at all.
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, fmt.Errorf("%w: unbalanced quotes in request", ErrProtocol)
+					}
+					done = true
+				} else {
+					current = append(current, c)
+				}
+			case inSingle:
+				if i >= len(line) {
+					return nil, fmt.Errorf("%w: unbalanced quotes in request", ErrProtocol)
+				}
+				c := line[i]
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					current = append(current, '\'')
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, fmt.Errorf("%w: unbalanced quotes in request", ErrProtocol)
+					}
+					done = true
+				} else {
+					current = append(current, c)
+				}
+			default:
+				if i >= len(line) {
+					done = true
+					break
+				}
+				switch c := line[i]; {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDouble = true
+				case c == '\'':
+					inSingle = true
+				default:
+					current = append(current, c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, string(current))
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
 }
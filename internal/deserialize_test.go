@@ -0,0 +1,205 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// chunkReader returns reads of random length, up to max bytes, the way a
+// socket delivers a large payload in pieces.
+type chunkReader struct {
+	r   io.Reader
+	max int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if n := 1 + rand.IntN(c.max); n < len(p) {
+		p = p[:n]
+	}
+	return c.r.Read(p)
+}
+
+// fragmented returns the ways a stream can be split into short reads.
+func fragmented(data []byte) map[string]io.Reader {
+	return map[string]io.Reader{
+		"whole":    bytes.NewReader(data),
+		"one byte": iotest.OneByteReader(bytes.NewReader(data)),
+		"half":     iotest.HalfReader(bytes.NewReader(data)),
+		"data err": iotest.DataErrReader(bytes.NewReader(data)),
+		"chunks":   &chunkReader{r: bytes.NewReader(data), max: 1500},
+	}
+}
+
+func TestReadBulkStrings(t *testing.T) {
+	large := strings.Repeat("0123456789\r\nabcdef", 150*1024)
+	args := []string{
+		"",
+		"\r\n",
+		"a\r\nb",
+		"\r\n$3\r\nSET\r\n",
+		"\x00\xff\r",
+		large,
+		"\n",
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write(BulkArray(args...))
+	for _, arg := range args {
+		w.Write(Bulk(arg))
+	}
+	w.Flush()
+	if !strings.Contains(buf.String(), "$0\r\n\r\n") {
+		t.Fatalf("empty bulk not written as $0")
+	}
+
+	for name, rd := range fragmented(buf.Bytes()) {
+		t.Run(name, func(t *testing.T) {
+			r := NewResp(rd)
+			cmd, err := r.ReadCommand()
+			if err != nil {
+				t.Fatalf("ReadCommand: %v", err)
+			}
+			if len(cmd.Array) != len(args) {
+				t.Fatalf("read %d arguments, want %d", len(cmd.Array), len(args))
+			}
+			for i, arg := range args {
+				if cmd.Array[i].Bulk != arg {
+					t.Errorf("argument %d: read %d bytes, want %d", i, len(cmd.Array[i].Bulk), len(arg))
+				}
+			}
+			for i, arg := range args {
+				v, err := r.Read()
+				if err != nil {
+					t.Fatalf("Read bulk %d: %v", i, err)
+				}
+				if v.Type != KindBulk || v.Bulk != arg {
+					t.Errorf("bulk %d: read %v of %d bytes, want %d", i, v.Type, len(v.Bulk), len(arg))
+				}
+			}
+			if _, err := r.Read(); err != io.EOF {
+				t.Errorf("after the last frame: got %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestReadTruncatedBulk(t *testing.T) {
+	frames := []string{
+		"$5\r\nab",
+		"$3\r\nabc",
+		"$3\r\nabc\r",
+		"*1\r\n$1048576\r\n" + strings.Repeat("x", 1<<19),
+	}
+	for _, frame := range frames {
+		for name, rd := range fragmented([]byte(frame)) {
+			_, err := NewResp(rd).Read()
+			if err != io.ErrUnexpectedEOF && err != io.EOF {
+				t.Errorf("%s, %.20q: got %v, want an EOF error", name, frame, err)
+			}
+		}
+	}
+	if _, err := NewResp(strings.NewReader("$3\r\nabcd\r\n")).Read(); !errors.Is(err, ErrProtocol) {
+		t.Errorf("bulk longer than its length: got %v, want ErrProtocol", err)
+	}
+}
+
+func TestReadCommandRejectsNesting(t *testing.T) {
+	// Enough nested headers to overflow the stack if they were followed.
+	frame := strings.Repeat("*1\r\n", 1<<20)
+	_, err := NewResp(strings.NewReader(frame)).ReadCommand()
+	if !errors.Is(err, ErrProtocol) {
+		t.Fatalf("got %v, want ErrProtocol", err)
+	}
+	_, err = NewResp(strings.NewReader("*2\r\n$3\r\nGET\r\n:1\r\n")).ReadCommand()
+	if !errors.Is(err, ErrProtocol) {
+		t.Fatalf("integer argument: got %v, want ErrProtocol", err)
+	}
+}
+
+func TestReadLimitsNesting(t *testing.T) {
+	frame := strings.Repeat("*1\r\n", 1<<20)
+	if _, err := NewResp(strings.NewReader(frame)).Read(); !errors.Is(err, ErrProtocol) {
+		t.Fatalf("got %v, want ErrProtocol", err)
+	}
+	frame = strings.Repeat("*1\r\n", maxNestingDepth) + ":1\r\n"
+	if _, err := NewResp(strings.NewReader(frame)).Read(); err != nil {
+		t.Fatalf("%d levels: %v", maxNestingDepth, err)
+	}
+}
+
+var readerSeeds = []string{
+	"*1\r\n$4\r\nPING\r\n",
+	"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$0\r\n\r\n",
+	"*-1\r\n",
+	"*0\r\n",
+	"PING\r\n",
+	"SET k \"a\\x41\\n\" 'b'\r\n",
+	"%1\r\n+a\r\n:1\r\n",
+	"~2\r\n#t\r\n,1.5\r\n",
+	"=7\r\ntxt:abc\r\n",
+	"!3\r\nERR\r\n",
+	"*1\r\n*1\r\n*1\r\n_\r\n",
+	"$-1\r\n",
+	"$3\r\na\r\n\r\n",
+}
+
+func FuzzReadCommand(f *testing.F) {
+	for _, seed := range readerSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := NewResp(bytes.NewReader(data))
+		for {
+			v, err := r.ReadCommand()
+			if err != nil {
+				return
+			}
+			if v.Type != KindArray {
+				t.Fatalf("request of type %v", v.Type)
+			}
+			// A request survives being written out and read back.
+			args := make([]string, len(v.Array))
+			for i, arg := range v.Array {
+				if arg.Type != KindBulk {
+					t.Fatalf("argument of type %v", arg.Type)
+				}
+				args[i] = arg.Bulk
+			}
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.Write(BulkArray(args...))
+			w.Flush()
+			again, err := NewResp(&buf).ReadCommand()
+			if err != nil {
+				t.Fatalf("re-reading %q: %v", buf.String(), err)
+			}
+			if len(again.Array) != len(args) {
+				t.Fatalf("re-read %d arguments, want %d", len(again.Array), len(args))
+			}
+			for i := range args {
+				if again.Array[i].Bulk != args[i] {
+					t.Fatalf("argument %d: got %q, want %q", i, again.Array[i].Bulk, args[i])
+				}
+			}
+		}
+	})
+}
+
+func FuzzRead(f *testing.F) {
+	for _, seed := range readerSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := NewResp(bytes.NewReader(data))
+		for {
+			if _, err := r.Read(); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,468 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/lists"
+	"github.com/codecrafters-io/redis-starter-go/internal/streams"
+)
+
+// Rough per-allocation overheads used to estimate memory usage. They are
+// in the ballpark of what Redis pays for a dict entry plus its key and value
+// objects, for a field of a hash or an entry of a stream, and for a
+// quicklist, one of its nodes and an element packed in a node.
+const (
+	keyEntryOverhead    = 56
+	hashOverhead        = 48
+	hashFieldOverhead   = 24
+	streamOverhead      = 64
+	streamEntryOverhead = 48
+	listOverhead        = 48
+	listNodeOverhead    = 40
+	listEntryOverhead   = 2
+)
+
+// stringSize estimates the memory held by a string value.
+func stringSize(s string) int64 {
+	return int64(len(s)) + 16
+}
+
+func hashFieldSize(field, value string) int64 {
+	return hashFieldOverhead + int64(len(field)+len(value))
+}
+
+func streamEntrySize(id string, fields map[string]string) int64 {
+	size := streamEntryOverhead + int64(len(id))
+	for k, v := range fields {
+		size += hashFieldSize(k, v)
+	}
+	return size
+}
+
+// listSize estimates the memory used by a list. Lists keep their own
+// totals, so this does not walk the elements.
+func listSize(l *lists.List) int64 {
+	return listOverhead + int64(l.Nodes())*listNodeOverhead + int64(l.Len())*listEntryOverhead + int64(l.Bytes())
+}
+
+// valueSize estimates the memory used by value from scratch, walking every
+// element of a collection.
+func valueSize(value RedisMapValue) int64 {
+	switch value.Keytype {
+	case "hash":
+		size := int64(hashOverhead)
+		for k, v := range value.Hash {
+			size += hashFieldSize(k, v)
+		}
+		return size
+	case "stream":
+		size := int64(streamOverhead)
+		value.Stream.RangeEach("-", "+", func(entry *streams.StreamEntry) {
+			size += streamEntrySize(entry.ID, entry.Value)
+		})
+		return size
+	case "list":
+		return listSize(value.List)
+	}
+	return stringSize(value.Val)
+}
+
+// entrySize estimates the memory used by a keyspace entry, key included.
+func entrySize(key string, value RedisMapValue) int64 {
+	return keyEntryOverhead + int64(len(key)) + value.Size
+}
+
+// usedMemory is the estimated size of the whole keyspace, kept up to date
+// by every write.
+var usedMemory atomic.Int64
+
+// growKey accounts for delta bytes added to the collection stored at key,
+// which was changed in place.
+func (db *DB) growKey(key string, delta int64) {
+	dirty.Add(1)
+	sh := db.shard(key)
+	value := sh.dict[key]
+	value.Size += delta
+	sh.dict[key] = value
+	sh.used += delta
+	addUsedMemory(delta)
+}
+
+// hashSet sets field of the hash stored at key and reports whether the field
+// is new. A listpack encoded hash that grows past the listpack limits is
+// converted to a hashtable, which indexes its fields for HSCAN.
+func (db *DB) hashSet(key string, value RedisMapValue, field, val string) bool {
+	old, exists := value.Hash[field]
+	value.Hash[field] = val
+	sh := db.shard(key)
+	entry := sh.dict[key]
+	if entry.Encoding == encodingListpack && !hashFitsListpack(len(value.Hash), field, val) {
+		entry.Encoding = encodingHashtable
+	}
+	if entry.Encoding == encodingHashtable && entry.Fields == nil {
+		entry.Fields = newScanTable()
+		for f := range value.Hash {
+			entry.Fields.add(f)
+		}
+		sh.dict[key] = entry
+	} else if !exists && entry.Fields != nil {
+		entry.Fields.add(field)
+	}
+	if exists {
+		db.growKey(key, int64(len(val)-len(old)))
+	} else {
+		db.growKey(key, hashFieldSize(field, val))
+	}
+	return !exists
+}
+
+// listResized brings the size and the encoding recorded for the list stored
+// at key up to date after it was changed in place.
+func (db *DB) listResized(key string) {
+	value := db.shard(key).dict[key]
+	db.growKey(key, listSize(value.List)-value.Size)
+	db.setEncoding(key, listEncoding(value.List))
+}
+
+// streamAdd appends an entry to the stream stored at key.
+func (db *DB) streamAdd(key string, value RedisMapValue, id string, fields map[string]string) {
+	value.Stream.AddEntry(id, fields)
+	db.growKey(key, streamEntrySize(id, fields))
+}
+
+// Eviction policies, as named by maxmemory-policy.
+const (
+	policyNoeviction = iota
+	policyAllkeysLRU
+	policyAllkeysLFU
+	policyAllkeysRandom
+	policyVolatileLRU
+	policyVolatileLFU
+	policyVolatileRandom
+	policyVolatileTTL
+)
+
+var policyNames = []string{
+	"noeviction",
+	"allkeys-lru",
+	"allkeys-lfu",
+	"allkeys-random",
+	"volatile-lru",
+	"volatile-lfu",
+	"volatile-random",
+	"volatile-ttl",
+}
+
+var (
+	maxmemory        atomic.Int64
+	maxmemoryPolicy  atomic.Int32
+	maxmemorySamples atomic.Int32
+)
+
+func init() {
+	maxmemorySamples.Store(5)
+}
+
+var statEvictedKeys atomic.Int64
+
+func isLFUPolicy(policy int32) bool {
+	return policy == policyAllkeysLFU || policy == policyVolatileLFU
+}
+
+func isVolatilePolicy(policy int32) bool {
+	return policy >= policyVolatileLRU
+}
+
+// parseMemory parses a byte count with an optional k, kb, m, mb, g or gb
+// suffix, where k is 1000 and kb is 1024 as in redis.conf.
+func parseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	s = strings.ToLower(s)
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mul = strings.TrimSuffix(s, u.suffix), u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mul {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// ConfigSet validates and applies a runtime configuration parameter, for
+// both the command line and CONFIG SET.
+func ConfigSet(name, value string) error {
+	name = strings.ToLower(name)
+	if err := applyConfig(name, value); err != nil {
+		return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %w", name, err)
+	}
+	return nil
+}
+
+var errUnknownConfig = errors.New("unknown option")
+
+func applyConfig(name, value string) error {
+	switch name {
+	case "maxmemory":
+		n, err := parseMemory(value)
+		if err != nil {
+			return err
+		}
+		maxmemory.Store(n)
+	case "maxmemory-policy":
+		i := slices.Index(policyNames, strings.ToLower(value))
+		if i < 0 {
+			return errors.New("argument(s) must be one of the following: " + strings.Join(policyNames, ", "))
+		}
+		maxmemoryPolicy.Store(int32(i))
+		value = policyNames[i]
+	case "maxmemory-samples":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 64 {
+			return errors.New("argument must be between 1 and 64 inclusive")
+		}
+		maxmemorySamples.Store(int32(n))
+	case "hash-max-listpack-entries":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return errors.New("argument couldn't be parsed into an integer")
+		}
+		hashMaxListpackEntries.Store(n)
+	case "hash-max-listpack-value":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return errors.New("argument couldn't be parsed into an integer")
+		}
+		hashMaxListpackValue.Store(n)
+	case "list-max-listpack-size":
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return errors.New("argument couldn't be parsed into an integer")
+		}
+		listMaxListpackSize.Store(int32(n))
+	default:
+		return errUnknownConfig
+	}
+	SetConfig(name, value)
+	return nil
+}
+
+// The LRU clock counts seconds in 24 bits, like Redis' LRU_CLOCK, and
+// wraps around every 194 days.
+const lruClockMax = 1<<24 - 1
+
+func lruClock() uint32 {
+	return uint32(time.Now().Unix()) & lruClockMax
+}
+
+// idleTime returns how long ago an LRU clock value was taken.
+func idleTime(lru uint32) time.Duration {
+	now := lruClock()
+	if now >= lru {
+		return time.Duration(now-lru) * time.Second
+	}
+	return time.Duration(lruClockMax-lru+now) * time.Second
+}
+
+// Under an LFU policy the LRU field instead holds the time of the last
+// decrement in minutes (16 bits) followed by a logarithmic access counter
+// (8 bits). New keys start at lfuInitVal so that they are not evicted before
+// they had a chance to be used.
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = 1
+)
+
+func lfuTimeInMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & 0xffff
+}
+
+func lfuTimeElapsed(ldt uint32) uint32 {
+	now := lfuTimeInMinutes()
+	if now >= ldt {
+		return now - ldt
+	}
+	return 0xffff - ldt + now
+}
+
+// lfuLogIncr increments counter with a probability that falls as it grows,
+// so that 8 bits cover millions of accesses.
+func lfuLogIncr(counter uint32) uint32 {
+	if counter == 255 {
+		return counter
+	}
+	base := float64(counter) - lfuInitVal
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1.0/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecrAndReturn returns the counter of lru, decayed by one for every
+// lfuDecayTime minutes since it was last decremented.
+func lfuDecrAndReturn(lru uint32) uint32 {
+	ldt, counter := lru>>8, lru&255
+	periods := lfuTimeElapsed(ldt) / lfuDecayTime
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// initialLRU is the LRU field of a newly created key.
+func initialLRU() uint32 {
+	if isLFUPolicy(maxmemoryPolicy.Load()) {
+		return lfuTimeInMinutes()<<8 | lfuInitVal
+	}
+	return lruClock()
+}
+
+// touchedLRU is the LRU field of a key after an access.
+func touchedLRU(lru uint32) uint32 {
+	if isLFUPolicy(maxmemoryPolicy.Load()) {
+		counter := lfuLogIncr(lfuDecrAndReturn(lru))
+		return lfuTimeInMinutes()<<8 | counter
+	}
+	return lruClock()
+}
+
+// evictionCandidate is a sampled key; the one with the highest score is
+// evicted first.
+type evictionCandidate struct {
+	db    *DB
+	key   string
+	score float64
+}
+
+func evictionScore(policy int32, value RedisMapValue) float64 {
+	switch policy {
+	case policyAllkeysLRU, policyVolatileLRU:
+		return float64(idleTime(value.LRU))
+	case policyAllkeysLFU, policyVolatileLFU:
+		return float64(255 - lfuDecrAndReturn(value.LRU))
+	case policyVolatileTTL:
+		return -float64(value.TTL.UnixMilli())
+	}
+	return rand.Float64()
+}
+
+// sampleEvictionCandidate looks at up to maxmemory-samples keys of db and
+// returns the best one to evict under policy. The keys come from the first
+// non-empty shard after a random one, so that only one shard is locked.
+func sampleEvictionCandidate(db *DB, policy int32) (evictionCandidate, bool) {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+	start := rand.IntN(shardCount)
+	for i := range shardCount {
+		if candidate, ok := sampleShard(db, db.shards[(start+i)%shardCount], policy); ok {
+			return candidate, true
+		}
+	}
+	return evictionCandidate{}, false
+}
+
+func sampleShard(db *DB, sh *shard, policy int32) (evictionCandidate, bool) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	samples := int(maxmemorySamples.Load())
+	best, found := evictionCandidate{}, false
+	consider := func(key string) {
+		score := evictionScore(policy, sh.dict[key])
+		if !found || score > best.score {
+			best, found = evictionCandidate{db, key, score}, true
+		}
+	}
+	n := 0
+	if isVolatilePolicy(policy) {
+		for key := range sh.expires {
+			if n == samples {
+				break
+			}
+			consider(key)
+			n++
+		}
+	} else {
+		for key := range sh.dict {
+			if n == samples {
+				break
+			}
+			consider(key)
+			n++
+		}
+	}
+	return best, found
+}
+
+// performEvictions evicts keys until the keyspace fits in maxmemory and
+// reports whether it does. Like Redis' approximated algorithms, each round
+// samples a few keys per database and evicts the best one among them.
+func performEvictions() bool {
+	limit := maxmemory.Load()
+	if limit == 0 || usedMemory.Load() <= limit {
+		return true
+	}
+	policy := maxmemoryPolicy.Load()
+	if policy == policyNoeviction {
+		return false
+	}
+	for usedMemory.Load() > limit {
+		best, found := evictionCandidate{}, false
+		for _, db := range dbs {
+			candidate, ok := sampleEvictionCandidate(db, policy)
+			if ok && (!found || candidate.score > best.score) {
+				best, found = candidate, true
+			}
+		}
+		if !found {
+			// Nothing left that the policy allows to evict.
+			return false
+		}
+		evictKey(best.db, best.key)
+	}
+	return true
+}
+
+func evictKey(db *DB, key string) {
+	defer lockKeys(db, []string{key})()
+	if _, ok := db.shard(key).dict[key]; !ok {
+		// Someone else removed it since it was sampled.
+		return
+	}
+	db.removeKey(key)
+	statEvictedKeys.Add(1)
+	propagate(db.id, "DEL", key)
+}
+
+func bytesToHuman(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,124 @@
+package util
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Tuning of the active expire cycle, following Redis' defaults: the cycle
+// runs activeExpireHz times a second and may use up to
+// activeExpireSlowTimePerc percent of each period. Within a database it
+// keeps sampling activeExpireKeysPerLoop volatile keys at a time for as long
+// as more than activeExpireAcceptableStale percent of a sample had expired.
+const (
+	activeExpireHz              = 10
+	activeExpireSlowTimePerc    = 25
+	activeExpireKeysPerLoop     = 20
+	activeExpireAcceptableStale = 10
+)
+
+// Expiration statistics reported by INFO.
+var (
+	statExpiredKeys           atomic.Int64
+	statExpiredTimeCapReached atomic.Int64
+	statExpireCycleTime       atomic.Int64
+	statExpiredStalePerc      atomic.Int64
+)
+
+// PropagateHook, when set, receives every change to the keyspace so it can
+// reach replicas: the write commands clients run, and those the server
+// issues on its own, such as the DEL of an expired key. It is called with
+// keyspace locks held, in the order the changes took effect, so it must only
+// queue the command and leave the network writes to another goroutine.
+var PropagateHook func(db int, cmd Value)
+
+func propagate(db int, args ...string) {
+	propagateCommand(db, BulkArray(args...))
+}
+
+func propagateCommand(db int, cmd Value) {
+	if PropagateHook != nil {
+		PropagateHook(db, cmd)
+	}
+}
+
+// expireKey deletes a key whose TTL has passed. Callers hold the lock of
+// key's shard.
+func (db *DB) expireKey(key string) {
+	db.removeKey(key)
+	statExpiredKeys.Add(1)
+	propagate(db.id, "DEL", key)
+}
+
+// StartActiveExpire launches the background cycle that reclaims expired
+// keys nobody reads any more. Replicas must not call it: they wait for the
+// DEL sent by their master instead.
+func StartActiveExpire() {
+	go func() {
+		ticker := time.NewTicker(time.Second / activeExpireHz)
+		defer ticker.Stop()
+		for range ticker.C {
+			activeExpireCycle()
+		}
+	}()
+}
+
+// expireNextShard is the shard, counted across all databases, that the
+// next cycle starts from, so that a cycle cut short by its time budget does
+// not starve the later ones.
+var expireNextShard int
+
+func activeExpireCycle() {
+	start := time.Now()
+	budget := time.Second / activeExpireHz * activeExpireSlowTimePerc / 100
+	defer func() {
+		statExpireCycleTime.Add(int64(time.Since(start)))
+	}()
+	var totalSampled, totalExpired int
+	defer func() {
+		if totalSampled > 0 {
+			statExpiredStalePerc.Store(int64(totalExpired * 100 / totalSampled))
+		}
+	}()
+	n := len(dbs) * shardCount
+	for i := 0; i < n; i++ {
+		slot := expireNextShard % n
+		expireNextShard++
+		for {
+			sampled, expired := expireSample(dbs[slot/shardCount], slot%shardCount)
+			totalSampled += sampled
+			totalExpired += expired
+			if time.Since(start) > budget {
+				statExpiredTimeCapReached.Add(1)
+				return
+			}
+			if sampled == 0 || expired*100/sampled <= activeExpireAcceptableStale {
+				break
+			}
+		}
+	}
+}
+
+// expireSample checks up to activeExpireKeysPerLoop volatile keys of one
+// shard, relying on Go's randomized map iteration order to pick them, and
+// deletes those that have expired. Only that shard is locked, and only for
+// one sample, so that clients are not stalled by a long cycle.
+func expireSample(db *DB, index int) (sampled, expired int) {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+	sh := db.shards[index]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	now := time.Now()
+	for key, ttl := range sh.expires {
+		if sampled == activeExpireKeysPerLoop {
+			break
+		}
+		sampled++
+		if now.After(ttl) {
+			db.expireKey(key)
+			expired++
+		}
+	}
+	return sampled, expired
+}
@@ -0,0 +1,101 @@
+package util
+
+// globMatch reports whether s matches pattern using Redis' glob rules:
+// '*' matches any sequence, '?' any single byte, '[...]' a set of bytes with
+// optional '^' negation and 'a-z' ranges, and '\' escapes the next byte.
+func globMatch(pattern, s string) bool {
+	p, i := 0, 0
+	// Position to resume from after the last '*', for backtracking.
+	starP, starI := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				starP, starI = p, i
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if next, ok := matchClass(pattern, p, s[i]); ok {
+					p = next
+					i++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if pattern[p+1] == s[i] {
+						p += 2
+						i++
+						continue
+					}
+					break
+				}
+				fallthrough
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starI++
+		p, i = starP, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the class starting at pattern[start], which
+// is '['. It returns the position following the class and whether c is in
+// it. An unterminated class extends to the end of the pattern, like in
+// Redis.
+func matchClass(pattern string, start int, c byte) (int, bool) {
+	p := start + 1
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+	match := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				match = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				match = true
+			}
+			p += 2
+		default:
+			if pattern[p] == c {
+				match = true
+			}
+		}
+		p++
+	}
+	if p < len(pattern) {
+		// Skip the closing ']'.
+		p++
+	}
+	return p, match != negate
+}
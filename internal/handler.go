@@ -2,221 +2,249 @@ package util
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/fs"
+	"math"
+	"math/big"
+	"math/rand/v2"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/codecrafters-io/redis-starter-go/internal/lists"
 	"github.com/codecrafters-io/redis-starter-go/internal/streams"
 	rdb "github.com/heisenberg8055/redis-rdb"
 	"github.com/heisenberg8055/redis-rdb/nopdecoder"
 )
 
+// RedisMapValue is a keyspace entry. Keytype names the kind of value held
+// ("string", "hash", "list" or "stream") and selects which of Val, Hash,
+// List or Stream carries it; the other fields are left empty.
+//
+// Size is the estimated memory used by the value, kept current as it
+// changes. LRU records the last access for eviction: a clock value, or
+// under an LFU policy the decay time and access counter. Encoding is the
+// representation reported by OBJECT ENCODING. Fields indexes the fields of
+// a hashtable encoded Hash for HSCAN; listpack hashes are small enough to
+// be returned in one call and have none.
 type RedisMapValue struct {
-	Val     string
-	TTL     time.Time
-	Keytype string
-	Stream  *streams.Stream
+	Val      string
+	Hash     map[string]string
+	Fields   *scanTable
+	List     *lists.List
+	Stream   *streams.Stream
+	TTL      time.Time
+	Keytype  string
+	Size     int64
+	LRU      uint32
+	Encoding string
 }
 
+// decoder loads an RDB file into the keyspace. db is the database selected
+// by the last SELECTDB opcode, or -1 if it is out of range.
 type decoder struct {
 	nopdecoder.NopDecoder
+	db int
 }
 
-var Handlers = map[string]func([]Value) Value{
-	"PING":     ping,
-	"ECHO":     echo,
-	"SET":      set,
-	"GET":      get,
-	"HSET":     hset,
-	"HGET":     hget,
-	"HGETALL":  hgetall,
-	"DEL":      del,
-	"CONFIG":   config,
-	"KEYS":     keys,
-	"TYPE":     types,
-	"XADD":     xadd,
-	"XRANGE":   xrange,
-	"XREAD":    xread,
-	"INCR":     incr,
-	"INFO":     info,
-	"REPLCONF": replconf,
-	"PSYNC":    psync,
-}
-
-func ping(args []Value) Value {
+func ping(c *Client, args []Value) Value {
 	if len(args) == 0 {
-		return Value{Type: "string", Str: "PONG"}
+		return SimpleString("PONG")
 	}
-	return Value{Type: "string", Str: args[0].Bulk}
+	return Bulk(args[0].Bulk)
 }
 
-func echo(args []Value) Value {
-	return Value{Type: "string", Str: args[0].Bulk}
+func echo(c *Client, args []Value) Value {
+	return Bulk(args[0].Bulk)
 }
 
-var mp = map[string]RedisMapValue{}
-var mpMu = sync.RWMutex{}
-
-func set(args []Value) Value {
-	n := len(args)
-	switch n {
-	case 1:
-		return Value{Type: "error", Str: "ERR wrong number of arguments for 'set' command"}
-	case 2:
-		key := args[0].Bulk
-		value := args[1].Bulk
-		mpMu.Lock()
-		mp[key] = RedisMapValue{Val: value, TTL: time.Time{}, Keytype: "string"}
-		mpMu.Unlock()
-	case 3:
-		return Value{Type: "error", Str: "Err syntax error"}
-	case 4:
-		key := args[0].Bulk
-		value := args[1].Bulk
-		flag := strings.ToUpper(args[2].Bulk)
-		ttlString := args[3].Bulk
-		ttl, err := strconv.ParseInt(ttlString, 10, 64)
-		if err != nil {
-			return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
-		}
-		switch flag {
-		case "PX":
-			mpMu.Lock()
-			mp[key] = RedisMapValue{Val: value, TTL: time.Now().Local().Add(time.Millisecond * time.Duration(ttl)), Keytype: "string"}
-			mpMu.Unlock()
-		case "EX":
-			mpMu.Lock()
-			mp[key] = RedisMapValue{Val: value, TTL: time.Now().Local().Add(time.Second * time.Duration(ttl)), Keytype: "string"}
-			mpMu.Unlock()
-		default:
-			return Value{Type: "error", Str: "Err syntax error"}
+// parseExpireTime turns the argument of an EX, PX, EXAT or PXAT option into
+// a deadline. unit scales it to milliseconds and relative tells whether it is
+// an offset from now. name is the command reported in errors.
+func parseExpireTime(arg string, unit int64, relative bool, name string) (time.Time, error) {
+	when, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("ERR value is not an integer or out of range")
+	}
+	invalid := fmt.Errorf("ERR invalid expire time in '%s' command", name)
+	if when <= 0 || when > math.MaxInt64/unit {
+		return time.Time{}, invalid
+	}
+	when *= unit
+	if relative {
+		now := time.Now().UnixMilli()
+		if when > math.MaxInt64-now {
+			return time.Time{}, invalid
 		}
-	default:
-		return Value{Type: "error", Str: "ERR wrong number of arguments for 'set' command"}
+		when += now
 	}
-	return Value{Type: "string", Str: "OK"}
+	return time.UnixMilli(when), nil
 }
 
-func get(args []Value) Value {
-	if len(args) != 1 {
-		return Value{Type: "error", Str: "ERR wrong number of arguments for 'get' command"}
-	}
-	osArgs := os.Args
-	if len(osArgs) == 5 {
-		dir := os.Args[2]
-		fileName := os.Args[4]
-		f, err := os.Open(dir + "/" + fileName)
-		if err != nil {
-			return Value{Type: "error", Str: err.Error()}
-		}
-		err = rdb.Decode(f, &decoder{})
-		if err != nil {
-			return Value{Type: "error", Str: err.Error()}
+// expireOptions maps the expiry options of SET and GETEX to the unit and
+// kind of their argument.
+var expireOptions = map[string]struct {
+	unit     int64
+	relative bool
+}{
+	"EX":   {1000, true},
+	"PX":   {1, true},
+	"EXAT": {1000, false},
+	"PXAT": {1, false},
+}
+
+func set(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	val := args[1].Bulk
+	var nx, xx, get, keepTTL, hasExpire bool
+	var deadline time.Time
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(args[i].Bulk)
+		switch opt {
+		case "NX":
+			if xx {
+				return Error("ERR syntax error")
+			}
+			nx = true
+		case "XX":
+			if nx {
+				return Error("ERR syntax error")
+			}
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			if hasExpire {
+				return Error("ERR syntax error")
+			}
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasExpire || keepTTL || i+1 == len(args) {
+				return Error("ERR syntax error")
+			}
+			i++
+			option := expireOptions[opt]
+			when, err := parseExpireTime(args[i].Bulk, option.unit, option.relative, "set")
+			if err != nil {
+				return Error(err.Error())
+			}
+			hasExpire = true
+			deadline = when
+		default:
+			return Error("ERR syntax error")
 		}
-		mpMu.Lock()
-		value, ok := mp[args[0].Bulk]
-		mpMu.Unlock()
-		if !ok {
-			return Value{Type: "null"}
+	}
+	db := c.db()
+	old, exists := db.lookupKey(key)
+	if get && exists && old.Keytype != "string" {
+		return Error(ErrWrongType.Error())
+	}
+	reply := OK()
+	if get {
+		reply = NullBulk()
+		if exists {
+			reply = Bulk(old.Val)
 		}
-		if isExpired(value.TTL) {
-			delete(mp, args[0].Bulk)
-			return Value{Type: "null"}
+	}
+	if (nx && exists) || (xx && !exists) {
+		if get {
+			return reply
 		}
-		return Value{Type: "bulk", Num: len(value.Val), Bulk: value.Val}
+		return NullBulk()
 	}
-	key := args[0].Bulk
-	mpMu.Lock()
-	value, ok := mp[key]
-	mpMu.Unlock()
-	if !ok {
-		return Value{Type: "null"}
+	value := RedisMapValue{Val: val, TTL: deadline, Keytype: "string"}
+	if keepTTL && exists {
+		value.TTL = old.TTL
 	}
-	if isExpired(value.TTL) {
-		delete(mp, key)
-		return Value{Type: "null"}
+	// An absolute deadline may already be behind us, in which case the key
+	// is written and expires at once.
+	if hasExpire && !time.Now().Before(deadline) {
+		db.removeKey(key)
+		propagate(db.id, "DEL", key)
+		return reply
 	}
-	return Value{Type: "bulk", Bulk: value.Val, Num: len(value.Val)}
+	db.setKey(key, value)
+	return reply
 }
 
-var HSETs = map[string]map[string]string{}
-var HSETsMu = sync.RWMutex{}
+func get(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	value, ok, err := c.db().lookupKeyOfType(key, "string")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return NullBulk()
+	}
+	return Bulk(value.Val)
+}
 
-func hset(args []Value) Value {
+func hset(c *Client, args []Value) Value {
 	n := len(args)
-	if n < 3 || n&1 == 0 {
-		return Value{Type: "error", Str: "ERR wrong number of arguments for 'hset' command"}
-	} else {
-		hash := args[0].Bulk
-		HSETsMu.Lock()
-		if _, ok := HSETs[hash]; !ok {
-			HSETs[hash] = map[string]string{}
-		}
-		iter := 1
-		for i := 0; i < (n-1)/2; i++ {
-			key := args[1+i*iter].Bulk
-			iVal := args[2+i*iter].Bulk
-			HSETs[hash][key] = iVal
-			iter++
+	if n&1 == 0 {
+		return Error("ERR wrong number of arguments for 'hset' command")
+	}
+	key := args[0].Bulk
+	value, err := c.db().lookupHashForWrite(key)
+	if err != nil {
+		return Error(err.Error())
+	}
+	added := 0
+	for i := 1; i < n; i += 2 {
+		if c.db().hashSet(key, value, args[i].Bulk, args[i+1].Bulk) {
+			added++
 		}
-		HSETsMu.Unlock()
-		return Value{Type: "string", Str: "OK"}
 	}
+	return Int(int64(added))
 }
 
-func hget(args []Value) Value {
-	if len(args) != 2 {
-		return Value{Type: "error", Str: "ERR wrong number of arguments for 'hget' command"}
+func hget(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	field := args[1].Bulk
+	value, ok, err := c.db().lookupKeyOfType(key, "hash")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return NullBulk()
 	}
-	hash := args[0].Bulk
-	key := args[1].Bulk
-	HSETsMu.Lock()
-	value, ok := HSETs[hash][key]
-	HSETsMu.Unlock()
+	fieldValue, ok := value.Hash[field]
 	if !ok {
-		return Value{Type: "null"}
+		return NullBulk()
 	}
-	return Value{Type: "bulk", Bulk: value}
+	return Bulk(fieldValue)
 }
 
-func hgetall(args []Value) Value {
-	if len(args) != 1 {
-		return Value{Type: "error", Str: "ERR wrong number of arguments for 'hgetall' command"}
+func hgetall(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	value, ok, err := c.db().lookupKeyOfType(key, "hash")
+	if err != nil {
+		return Error(err.Error())
 	}
-	hash := args[0].Bulk
-	HSETsMu.Lock()
-	value, ok := HSETs[hash]
-	HSETsMu.Unlock()
 	if !ok {
-		return Value{Type: "array", Num: 0}
-	} else {
-		ans := []Value{}
-		for key, val := range value {
-			ans = append(ans, Value{Type: "bulk", Num: len(key), Bulk: key})
-			ans = append(ans, Value{Type: "bulk", Num: len(val), Bulk: val})
-		}
-		return Value{Type: "array", Num: len(value) * 2, Array: ans}
+		return Map()
 	}
+	ans := make([]Value, 0, len(value.Hash)*2)
+	for field, fieldValue := range value.Hash {
+		ans = append(ans, Bulk(field))
+		ans = append(ans, Bulk(fieldValue))
+	}
+	return Map(ans...)
 }
 
-func del(args []Value) Value {
+func del(c *Client, args []Value) Value {
 	n := len(args)
-	if n == 0 {
-		return Value{Type: "error", Str: "Err wrong number of arguments for 'del' command"}
-	}
 	deletedKeys := 0
-	mpMu.Lock()
 	for i := 0; i < n; i++ {
-		if _, ok := mp[args[i].Bulk]; ok {
+		if c.db().deleteKey(args[i].Bulk) {
 			deletedKeys++
-			delete(mp, args[i].Bulk)
 		}
 	}
-	mpMu.Unlock()
-	return Value{Type: "integer", Str: strconv.Itoa(deletedKeys)}
+	return Int(int64(deletedKeys))
 }
 
 func isExpired(t time.Time) (expired bool) {
@@ -226,108 +254,209 @@ func isExpired(t time.Time) (expired bool) {
 	return time.Now().After(t)
 }
 
-func config(args []Value) Value {
-	n := len(args)
-	switch n {
-	case 0:
-		return Value{Type: "error", Str: "ERR wrong number of arguments for 'config' command"}
-	case 1:
-		subCommand := strings.ToUpper(args[0].Bulk)
-		if subCommand == "GET" {
-			return Value{Type: "error", Str: "ERR wrong number of arguments for 'config|get' command"}
-		} else {
-			return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'.", subCommand)}
-		}
-	case 2:
-		subCommand := strings.ToUpper(args[0].Bulk)
-		if subCommand == "GET" {
-			param := args[1].Bulk
-			ans := []Value{}
-			switch param {
-			case "dir":
-				val := os.Args[2]
-				ans = append(ans, Value{Type: "bulk", Bulk: "dir", Num: len("dir")})
-				ans = append(ans, Value{Type: "bulk", Bulk: val, Num: len(val)})
-				return Value{Type: "array", Num: 2, Array: ans}
-			case "dbfilename":
-				val := os.Args[4]
-				ans = append(ans, Value{Type: "bulk", Bulk: "dbfileName", Num: len("dbfileName")})
-				ans = append(ans, Value{Type: "bulk", Bulk: val, Num: len(val)})
-				return Value{Type: "array", Num: 2, Array: ans}
+func config(c *Client, args []Value) Value {
+	subCommand := strings.ToUpper(args[0].Bulk)
+	switch subCommand {
+	case "GET":
+		ans := []Value{}
+		for _, arg := range args[1:] {
+			param := strings.ToLower(arg.Bulk)
+			if val, ok := GetConfig(param); ok {
+				ans = append(ans, Bulk(param), Bulk(val))
+			}
+		}
+		return Map(ans...)
+	case "SET":
+		// The arity only says there is at least one pair.
+		if len(args)%2 == 0 {
+			return Error("ERR wrong number of arguments for 'config|set' command")
+		}
+		for i := 1; i < len(args); i += 2 {
+			err := ConfigSet(args[i].Bulk, args[i+1].Bulk)
+			if errors.Is(err, errUnknownConfig) {
+				return Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i].Bulk)
+			}
+			if err != nil {
+				return Error("ERR " + err.Error())
 			}
-		} else {
-			return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'.", args[0].Bulk)}
 		}
+		return OK()
+	}
+	return Errorf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[0].Bulk)
+}
+
+func (p *decoder) StartDatabase(n int) {
+	p.db = n
+	if n < 0 || n >= len(dbs) {
+		fmt.Printf("RDB selects DB %d, but only %d databases are configured; skipping its keys\n", n, len(dbs))
+		p.db = -1
 	}
-	return Value{}
 }
 
 func (p *decoder) Set(key, value []byte, expiry int64) {
-	if expiry != 0 {
-		mpMu.Lock()
-		mp[string(key)] = RedisMapValue{Val: string(value), TTL: time.Unix(expiry/1000, 0), Keytype: "string"}
-		mpMu.Unlock()
+	if p.db < 0 {
 		return
 	}
-	mpMu.Lock()
-	mp[string(key)] = RedisMapValue{Val: string(value), TTL: time.Time{}, Keytype: "string"}
-	mpMu.Unlock()
+	db := dbs[p.db]
+	defer lockKeys(db, []string{string(key)})()
+	db.setKey(string(key), RedisMapValue{Val: string(value), TTL: rdbExpiry(expiry), Keytype: "string"})
 }
 
-func keys(args []Value) Value {
-	n := len(args)
-	if n != 1 {
-		return Value{Type: "error", Str: "ERR wrong number of arguments for 'keys' command"}
+func (p *decoder) StartHash(key []byte, length, expiry int64) {
+	if p.db < 0 {
+		return
 	}
-	dir := os.Args[2]
-	fileName := os.Args[4]
-	f, err := os.Open(dir + "/" + fileName)
-	if err != nil {
-		return Value{Type: "error", Str: err.Error()}
+	db := dbs[p.db]
+	defer lockKeys(db, []string{string(key)})()
+	db.setKey(string(key), RedisMapValue{Hash: make(map[string]string, length), TTL: rdbExpiry(expiry), Keytype: "hash"})
+}
+
+func (p *decoder) Hset(key, field, value []byte) {
+	if p.db < 0 {
+		return
+	}
+	db := dbs[p.db]
+	defer lockKeys(db, []string{string(key)})()
+	db.hashSet(string(key), db.shard(string(key)).dict[string(key)], string(field), string(value))
+}
+
+func (p *decoder) StartList(key []byte, length, expiry int64) {
+	if p.db < 0 {
+		return
+	}
+	db := dbs[p.db]
+	defer lockKeys(db, []string{string(key)})()
+	db.setKey(string(key), RedisMapValue{List: lists.New(int(listMaxListpackSize.Load())), TTL: rdbExpiry(expiry), Keytype: "list"})
+}
+
+func (p *decoder) Rpush(key, value []byte) {
+	if p.db < 0 {
+		return
+	}
+	db := dbs[p.db]
+	defer lockKeys(db, []string{string(key)})()
+	db.shard(string(key)).dict[string(key)].List.PushTail(string(value))
+	db.listResized(string(key))
+}
+
+// EndList drops a list that turned out to be empty, which Redis never
+// stores.
+func (p *decoder) EndList(key []byte) {
+	if p.db < 0 {
+		return
+	}
+	db := dbs[p.db]
+	defer lockKeys(db, []string{string(key)})()
+	if db.shard(string(key)).dict[string(key)].List.Len() == 0 {
+		db.removeKey(string(key))
+	}
+}
+
+// LoadRDB fills the keyspace from the RDB file at path. A missing file is
+// not an error: the server then simply starts empty.
+func LoadRDB(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
 	}
-	err = rdb.Decode(f, &decoder{})
 	if err != nil {
-		return Value{Type: "error", Str: err.Error()}
+		return err
 	}
-	ans := []Value{}
-	mpMu.Lock()
-	for k, v := range mp {
-		if isExpired(v.TTL) {
-			delete(mp, k)
-			continue
-		}
-		ans = append(ans, Value{Type: "bulk", Bulk: k, Num: len(k)})
+	defer f.Close()
+	return rdb.Decode(f, &decoder{})
+}
+
+// rdbExpiry converts an RDB expiry in unix milliseconds, where 0 means the
+// key does not expire.
+func rdbExpiry(expiry int64) time.Time {
+	if expiry == 0 {
+		return time.Time{}
 	}
-	mpMu.Unlock()
-	return Value{Type: "array", Array: ans, Num: len(ans)}
+	return time.UnixMilli(expiry)
 }
 
-func types(args []Value) Value {
-	n := len(args)
-	if n == 0 {
-		return Value{Type: "error", Str: "Err wrong number of arguments for 'type' command"}
+func keys(c *Client, args []Value) Value {
+	pattern := args[0].Bulk
+	allKeys := pattern == "*"
+	db := c.db()
+	// A first pass drops the expired keys and counts the others, so the
+	// names can be written out as the second one finds them.
+	count := 0
+	for _, sh := range db.shards {
+		for k, v := range sh.dict {
+			if !allKeys && !globMatch(pattern, k) {
+				continue
+			}
+			if isExpired(v.TTL) {
+				db.expireKey(k)
+				continue
+			}
+			count++
+		}
 	}
+	return StreamArray(count, func(a *ArrayStream) {
+		for _, sh := range db.shards {
+			for k := range sh.dict {
+				if allKeys || globMatch(pattern, k) {
+					a.Add(Bulk(k))
+				}
+			}
+		}
+	})
+}
+
+func types(c *Client, args []Value) Value {
 	key := args[0].Bulk
-	mpMu.Lock()
-	value, ok := mp[key]
-	mpMu.Unlock()
+	value, ok := c.db().lookupKeyNoTouch(key)
 	if !ok {
-		return Value{Type: "string", Str: "none"}
+		return SimpleString("none")
 	}
-	return Value{Type: "string", Str: value.Keytype}
+	return SimpleString(value.Keytype)
 }
 
-func xadd(args []Value) Value {
+// object inspects the value stored at a key without counting as an access
+// to it.
+func object(c *Client, args []Value) Value {
+	subCommand := strings.ToUpper(args[0].Bulk)
+	switch subCommand {
+	case "ENCODING", "FREQ", "IDLETIME", "REFCOUNT":
+	default:
+		return Errorf("ERR unknown subcommand '%s'. Try OBJECT HELP.", args[0].Bulk)
+	}
+	value, ok := c.db().lookupKeyNoTouch(args[1].Bulk)
+	if !ok {
+		return NullBulk()
+	}
+	switch subCommand {
+	case "ENCODING":
+		return Bulk(value.Encoding)
+	case "FREQ":
+		if !isLFUPolicy(maxmemoryPolicy.Load()) {
+			return Error("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return Int(int64(lfuDecrAndReturn(value.LRU)))
+	case "IDLETIME":
+		if isLFUPolicy(maxmemoryPolicy.Load()) {
+			return Error("ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return Int(int64(idleTime(value.LRU) / time.Second))
+	}
+	// Values are never shared between keys.
+	return Int(1)
+}
+
+func xadd(c *Client, args []Value) Value {
 	n := len(args)
-	if n == 0 || n%2 == 1 {
-		return Value{Type: "error", Str: "ERR wrong number of arguments for 'xadd' command"}
+	if n%2 == 1 {
+		return Error("ERR wrong number of arguments for 'xadd' command")
 	}
 	streamName := args[0].Bulk
 	streamID := args[1].Bulk
 	streamIdArray := strings.Split(streamID, "-")
-	mpMu.Lock()
-	value, ok := mp[streamName]
-	mpMu.Unlock()
+	value, ok, err := c.db().lookupKeyOfType(streamName, "stream")
+	if err != nil {
+		return Error(err.Error())
+	}
 	mapVal := make(map[string]string)
 	for i := 2; i < n; i += 2 {
 		k := args[i].Bulk
@@ -336,33 +465,31 @@ func xadd(args []Value) Value {
 	}
 	switch {
 	case streamID == "0-0":
-		return Value{Type: "error", Str: "ERR The ID specified in XADD must be greater than 0-0"}
+		return Error("ERR The ID specified in XADD must be greater than 0-0")
 	case streamID == "*":
 		if !ok {
 			timeUnix := time.Now().Unix() * 1000
 			newStream := streams.NewStream()
 			newId := fmt.Sprintf("%d-0", timeUnix)
 			newStream.AddEntry(newId, mapVal)
-			mpMu.Lock()
-			mp[streamName] = RedisMapValue{Stream: newStream, TTL: time.Time{}, Keytype: "stream"}
-			mpMu.Unlock()
-			return Value{Type: "bulk", Bulk: newId, Num: len(newId)}
+			c.db().setKey(streamName, RedisMapValue{Stream: newStream, TTL: time.Time{}, Keytype: "stream"})
+			return Bulk(newId)
 		} else {
 			prevSeq := value.Stream.LastSeq
 			prevTime := value.Stream.LastTime
 			prevId := value.Stream.Tail.ID
 			timeUnix := time.Now().Unix() * 1000
 			if prevId > fmt.Sprintf("%d-0", timeUnix) {
-				return Value{Type: "error", Str: "ERR The ID specified in XADD is equal or smaller than the target stream top item"}
+				return Error("ERR The ID specified in XADD is equal or smaller than the target stream top item")
 			}
 			if timeUnix == prevTime {
 				newId := fmt.Sprintf("%d-%d", timeUnix, prevSeq+1)
-				value.Stream.AddEntry(newId, mapVal)
-				return Value{Type: "bulk", Num: len(newId), Bulk: newId}
+				c.db().streamAdd(streamName, value, newId, mapVal)
+				return Bulk(newId)
 			} else {
 				newId := fmt.Sprintf("%d-%d", timeUnix, 0)
-				value.Stream.AddEntry(newId, mapVal)
-				return Value{Type: "bulk", Num: len(newId), Bulk: newId}
+				c.db().streamAdd(streamName, value, newId, mapVal)
+				return Bulk(newId)
 			}
 		}
 	case streamIdArray[len(streamIdArray)-1] == "*":
@@ -371,95 +498,101 @@ func xadd(args []Value) Value {
 			switch {
 			case streamID == "0-*":
 				newStream.AddEntry("0-1", mapVal)
-				mpMu.Lock()
-				mp[streamName] = RedisMapValue{TTL: time.Time{}, Keytype: "stream", Stream: newStream}
-				mpMu.Unlock()
-				return Value{Type: "bulk", Num: 3, Bulk: "0-1"}
+				c.db().setKey(streamName, RedisMapValue{TTL: time.Time{}, Keytype: "stream", Stream: newStream})
+				return Bulk("0-1")
 			default:
 				newId := streamIdArray[0] + "-0"
 				newStream.AddEntry(newId, mapVal)
-				mpMu.Lock()
-				mp[streamName] = RedisMapValue{TTL: time.Time{}, Keytype: "stream", Stream: newStream}
-				mpMu.Unlock()
-				return Value{Type: "bulk", Num: len(newId), Bulk: newId}
+				c.db().setKey(streamName, RedisMapValue{TTL: time.Time{}, Keytype: "stream", Stream: newStream})
+				return Bulk(newId)
 			}
 		} else {
 			prevId := value.Stream.Tail.ID
 			prevIdArr := strings.Split(prevId, "-")
 			if prevIdArr[0] > streamIdArray[0] {
-				return Value{Type: "error", Str: "ERR The ID specified in XADD is equal or smaller than the target stream top item"}
+				return Error("ERR The ID specified in XADD is equal or smaller than the target stream top item")
 			}
 			prevIdSeq, _ := strconv.Atoi(prevIdArr[1])
 			if prevIdArr[0] == streamIdArray[0] {
 				nextID := streamIdArray[0] + fmt.Sprintf("-%d", prevIdSeq+1)
-				value.Stream.AddEntry(nextID, mapVal)
-				return Value{Type: "bulk", Num: len(nextID), Bulk: nextID}
+				c.db().streamAdd(streamName, value, nextID, mapVal)
+				return Bulk(nextID)
 			} else {
 				nextID := streamIdArray[0] + "-0"
-				value.Stream.AddEntry(nextID, mapVal)
-				return Value{Type: "bulk", Num: len(nextID), Bulk: nextID}
+				c.db().streamAdd(streamName, value, nextID, mapVal)
+				return Bulk(nextID)
 			}
 		}
 	default:
 		if !ok {
 			newStream := streams.NewStream()
 			newStream.AddEntry(streamID, mapVal)
-			mpMu.Lock()
-			mp[streamName] = RedisMapValue{TTL: time.Time{}, Keytype: "stream", Stream: newStream}
-			mpMu.Unlock()
+			c.db().setKey(streamName, RedisMapValue{TTL: time.Time{}, Keytype: "stream", Stream: newStream})
 		} else {
 			if value.Stream.Tail.ID >= streamID {
-				return Value{Type: "error", Str: "ERR The ID specified in XADD is equal or smaller than the target stream top item"}
+				return Error("ERR The ID specified in XADD is equal or smaller than the target stream top item")
 			}
-			value.Stream.AddEntry(streamID, mapVal)
+			c.db().streamAdd(streamName, value, streamID, mapVal)
 		}
-		return Value{Type: "bulk", Num: len(streamID), Bulk: streamID}
+		return Bulk(streamID)
 	}
 }
 
-func xrange(args []Value) Value {
+func xrange(c *Client, args []Value) Value {
 	n := len(args)
 	if n != 3 {
-		return Value{Type: "error", Str: "ERR wrong number of arguments for 'xrange' command"}
+		return Error("ERR syntax error")
 	}
 	key := args[0].Bulk
 	startIndex := args[1].Bulk
 	endIndex := args[2].Bulk
-	mpMu.Lock()
-	value, ok := mp[key]
-	mpMu.Unlock()
+	value, ok, err := c.db().lookupKeyOfType(key, "stream")
+	if err != nil {
+		return Error(err.Error())
+	}
 	if !ok {
-		return Value{Type: "array", Num: 0, Array: []Value{}}
+		return Array()
 	}
-	entries := value.Stream.RangeQuery(startIndex, endIndex)
-	entryArr := []Value{}
-	for _, entry := range entries {
-		arr := []Value{}
-		arr = append(arr, Value{Type: "bulk", Num: len(entry.ID), Bulk: entry.ID})
-		values := []Value{}
-		for k, v := range entry.Value {
-			values = append(values, Value{Type: "bulk", Num: len(k), Bulk: k})
-			values = append(values, Value{Type: "bulk", Num: len(v), Bulk: v})
-		}
-		arr = append(arr, Value{Type: "array", Num: len(values), Array: values})
-		entryArr = append(entryArr, Value{Type: "array", Num: len(arr), Array: arr})
+	count := 0
+	value.Stream.RangeEach(startIndex, endIndex, func(entry *streams.StreamEntry) {
+		count++
+	})
+	return StreamArray(count, func(a *ArrayStream) {
+		value.Stream.RangeEach(startIndex, endIndex, func(entry *streams.StreamEntry) {
+			a.Add(streamEntryValue(entry))
+		})
+	})
+}
+
+// streamEntryValue encodes an entry as an [id, [field, value, ...]] pair.
+func streamEntryValue(entry *streams.StreamEntry) Value {
+	values := make([]Value, 0, len(entry.Value)*2)
+	for k, v := range entry.Value {
+		values = append(values, Bulk(k))
+		values = append(values, Bulk(v))
 	}
-	return Value{Type: "array", Num: len(entryArr), Array: entryArr}
+	return Array(Bulk(entry.ID), Array(values...))
 }
 
-func xread(args []Value) Value {
+func xread(c *Client, args []Value) Value {
 	n := len(args)
-	if n < 3 || n&1 == 0 {
-		return Value{Type: "error", Str: "ERR wrong number of arguments for 'xread' command"}
+	if n&1 == 0 {
+		return Error("ERR wrong number of arguments for 'xread' command")
 	}
 	if args[0].Bulk != "streams" && args[0].Bulk != "block" {
-		return Value{Type: "error", Str: "Err systax error"}
+		return Error("ERR syntax error")
 	}
 	switch {
 	case args[0].Bulk == "block":
 		t, err := strconv.ParseInt(args[1].Bulk, 10, 64)
 		if err != nil {
-			return Value{Type: "error", Str: "Err invalid block time"}
+			return Error("ERR timeout is not an integer or out of range")
+		}
+		if c.keyspaceLocked {
+			// Inside EXEC the keyspace is locked, so no entry can arrive
+			// while we wait. As in Redis, the command does not block there
+			// and answers as if the timeout had expired.
+			return NullArray()
 		}
 		key := args[3].Bulk
 		var entry *streams.StreamEntry
@@ -468,11 +601,22 @@ func xread(args []Value) Value {
 			timer = time.NewTimer(time.Hour * 24 * 365)
 		}
 		defer timer.Stop()
-		stream, ok := mp[key]
+		unlock := c.lockKeys(key)
+		stream, ok, err := c.db().lookupKeyOfType(key, "stream")
+		unlock()
+		if err != nil {
+			return Error(err.Error())
+		}
 		if !ok {
-			return Value{Type: "null"}
+			return NullArray()
 		}
 		channel := stream.Stream.C
+		c.Blocked = true
+		c.BlockedOn = []string{key}
+		defer func() {
+			c.Blocked = false
+			c.BlockedOn = nil
+		}()
 		var wg sync.WaitGroup
 		wg.Add(1)
 		go func() {
@@ -488,87 +632,1764 @@ func xread(args []Value) Value {
 		}()
 		wg.Wait()
 		if entry == nil {
-			return Value{Type: "null"}
+			return NullArray()
 		}
 		ans := []Value{}
 		streamArr := []Value{}
-		entryArr := []Value{}
-		arr := []Value{}
-		arr = append(arr, Value{Type: "bulk", Num: len(entry.ID), Bulk: entry.ID})
-		values := []Value{}
-		for k, v := range entry.Value {
-			values = append(values, Value{Type: "bulk", Num: len(k), Bulk: k})
-			values = append(values, Value{Type: "bulk", Num: len(v), Bulk: v})
-		}
-		arr = append(arr, Value{Type: "array", Num: len(values), Array: values})
-		entryArr = append(entryArr, Value{Type: "array", Num: len(arr), Array: arr})
-		streamArr = append(streamArr, Value{Type: "bulk", Num: len(key), Bulk: key})
-		streamArr = append(streamArr, Value{Type: "array", Num: len(entryArr), Array: entryArr})
-		ans = append(ans, Value{Type: "array", Num: len(streamArr), Array: streamArr})
-		return Value{Type: "array", Num: len(ans), Array: ans}
+		entryArr := []Value{streamEntryValue(entry)}
+		streamArr = append(streamArr, Bulk(key))
+		streamArr = append(streamArr, Array(entryArr...))
+		ans = append(ans, Array(streamArr...))
+		return Array(ans...)
 	case args[0].Bulk == "streams":
 		ans := []Value{}
 		for i := 1; i <= n/2; i++ {
 			streamArr := []Value{}
 			key := args[i].Bulk
 			id := args[(n/2)+i].Bulk
-			mpMu.Lock()
-			value := mp[key]
-			mpMu.Unlock()
+			unlock := c.lockKeys(key)
+			value, ok, err := c.db().lookupKeyOfType(key, "stream")
+			var entries []*streams.StreamEntry
+			if ok {
+				entries = value.Stream.QueryXread(id)
+			}
+			unlock()
+			if err != nil {
+				return Error(err.Error())
+			}
+			if !ok {
+				continue
+			}
 			entryArr := []Value{}
-			entries := value.Stream.QueryXread(id)
 			for _, entry := range entries {
-				arr := []Value{}
-				arr = append(arr, Value{Type: "bulk", Num: len(entry.ID), Bulk: entry.ID})
-				values := []Value{}
-				for k, v := range entry.Value {
-					values = append(values, Value{Type: "bulk", Num: len(k), Bulk: k})
-					values = append(values, Value{Type: "bulk", Num: len(v), Bulk: v})
-				}
-				arr = append(arr, Value{Type: "array", Num: len(values), Array: values})
-				entryArr = append(entryArr, Value{Type: "array", Num: len(arr), Array: arr})
+				entryArr = append(entryArr, streamEntryValue(entry))
 			}
-			streamArr = append(streamArr, Value{Type: "bulk", Num: len(key), Bulk: key})
-			streamArr = append(streamArr, Value{Type: "array", Num: len(entryArr), Array: entryArr})
-			ans = append(ans, Value{Type: "array", Num: len(streamArr), Array: streamArr})
+			// Like Redis, streams with nothing new are left out of the reply.
+			if len(entryArr) == 0 {
+				continue
+			}
+			streamArr = append(streamArr, Bulk(key))
+			streamArr = append(streamArr, Array(entryArr...))
+			ans = append(ans, Array(streamArr...))
+		}
+		if len(ans) == 0 {
+			return NullArray()
+		}
+		return Array(ans...)
+	}
+	return Error("ERR syntax error")
+}
+
+// incrDecr adds delta to the integer stored at key, treating a missing key
+// as 0. The read and the write happen under one lock so that concurrent
+// increments are never lost.
+func incrDecr(c *Client, key string, delta int64) Value {
+	db := c.db()
+	value, ok, err := db.lookupKeyOfType(key, "string")
+	if err != nil {
+		return Error(err.Error())
+	}
+	var current int64
+	if ok {
+		current, err = strconv.ParseInt(value.Val, 10, 64)
+		if err != nil {
+			return Error("ERR value is not an integer or out of range")
 		}
-		return Value{Type: "array", Num: len(ans), Array: ans}
+	} else {
+		value = RedisMapValue{Keytype: "string"}
+	}
+	if (delta < 0 && current < math.MinInt64-delta) || (delta > 0 && current > math.MaxInt64-delta) {
+		return Error("ERR increment or decrement would overflow")
+	}
+	current += delta
+	// The entry is updated in place, so its TTL survives the increment.
+	value.Val = strconv.FormatInt(current, 10)
+	value.Encoding = encodingInt
+	db.setKey(key, value)
+	return Int(current)
+}
+
+func incr(c *Client, args []Value) Value {
+	return incrDecr(c, args[0].Bulk, 1)
+}
+
+func decr(c *Client, args []Value) Value {
+	return incrDecr(c, args[0].Bulk, -1)
+}
+
+func incrby(c *Client, args []Value) Value {
+	delta, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Error("ERR value is not an integer or out of range")
+	}
+	return incrDecr(c, args[0].Bulk, delta)
+}
+
+func decrby(c *Client, args []Value) Value {
+	delta, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Error("ERR value is not an integer or out of range")
+	}
+	if delta == math.MinInt64 {
+		return Error("ERR decrement would overflow")
+	}
+	return incrDecr(c, args[0].Bulk, -delta)
+}
+
+// longDoublePrec is the mantissa precision of the x87 long double in which
+// Redis does the arithmetic of INCRBYFLOAT and HINCRBYFLOAT, and
+// longDoubleMaxExp the binary exponent past which it overflows.
+const (
+	longDoublePrec   = 64
+	longDoubleMaxExp = 16384
+)
+
+// parseLongDouble parses a float argument the way Redis does, at long
+// double precision, rejecting NaN and surrounding spaces.
+func parseLongDouble(s string) (*big.Float, bool) {
+	if s == "" || isSpace(s[0]) || isSpace(s[len(s)-1]) {
+		return nil, false
+	}
+	f, _, err := big.ParseFloat(s, 10, longDoublePrec, big.ToNearestEven)
+	return f, err == nil
+}
+
+// addLongDouble adds delta to current in long double precision, reporting
+// false if the sum is not finite.
+func addLongDouble(current, delta *big.Float) (*big.Float, bool) {
+	if current.IsInf() || delta.IsInf() {
+		return nil, false
+	}
+	sum := new(big.Float).SetPrec(longDoublePrec).Add(current, delta)
+	return sum, sum.MantExp(nil) <= longDoubleMaxExp
+}
+
+// formatLongDouble renders the result of INCRBYFLOAT and HINCRBYFLOAT with
+// 17 significant digits, in plain decimal notation and without trailing
+// zeros, so that 0.1 plus 0.2 reads 0.3 as in Redis.
+func formatLongDouble(f *big.Float) string {
+	if f.Sign() == 0 {
+		return "0"
+	}
+	mant, exp, _ := strings.Cut(f.Text('e', 16), "e")
+	sign := ""
+	if mant[0] == '-' {
+		sign, mant = "-", mant[1:]
+	}
+	digits := strings.Replace(mant, ".", "", 1)
+	point, _ := strconv.Atoi(exp)
+	point++
+	var whole, frac string
+	switch {
+	case point <= 0:
+		whole, frac = "0", strings.Repeat("0", -point)+digits
+	case point >= len(digits):
+		whole = digits + strings.Repeat("0", point-len(digits))
+	default:
+		whole, frac = digits[:point], digits[point:]
+	}
+	if frac = strings.TrimRight(frac, "0"); frac != "" {
+		return sign + whole + "." + frac
 	}
-	return Value{Type: "error", Str: "Err"}
+	return sign + whole
 }
 
-func incr(args []Value) Value {
+func incrbyfloat(c *Client, args []Value) Value {
 	key := args[0].Bulk
-	mpMu.Lock()
-	value, ok := mp[key]
-	mpMu.Unlock()
+	delta, ok := parseLongDouble(args[1].Bulk)
 	if !ok {
-		mp[key] = RedisMapValue{Keytype: "string", Val: "1"}
-		return Value{Type: "integer", Str: "1"}
+		return Error("ERR value is not a valid float")
 	}
-	updateNum := value.Val
-	updateCast, err := strconv.Atoi(updateNum)
+	db := c.db()
+	value, exists, err := db.lookupKeyOfType(key, "string")
 	if err != nil {
-		return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+		return Error(err.Error())
+	}
+	current := new(big.Float)
+	if exists {
+		if current, ok = parseLongDouble(value.Val); !ok {
+			return Error("ERR value is not a valid float")
+		}
+	} else {
+		value = RedisMapValue{Keytype: "string"}
+	}
+	sum, ok := addLongDouble(current, delta)
+	if !ok {
+		return Error("ERR increment would produce NaN or Infinity")
+	}
+	value.Val = formatLongDouble(sum)
+	// Like Redis, the result is kept as a string even if it is integral.
+	value.Encoding = encodingEmbstr
+	if len(value.Val) > embstrSizeLimit {
+		value.Encoding = encodingRaw
+	}
+	db.setKey(key, value)
+	return Bulk(value.Val)
+}
+
+// lookupHashForWrite returns the hash at key, creating an empty one if the
+// key does not exist.
+func (db *DB) lookupHashForWrite(key string) (RedisMapValue, error) {
+	value, ok, err := db.lookupKeyOfType(key, "hash")
+	if err != nil {
+		return RedisMapValue{}, err
+	}
+	if !ok {
+		value = RedisMapValue{Keytype: "hash", Hash: map[string]string{}}
+		db.setKey(key, value)
+	}
+	return value, nil
+}
+
+func hincrby(c *Client, args []Value) Value {
+	key, field := args[0].Bulk, args[1].Bulk
+	delta, err := strconv.ParseInt(args[2].Bulk, 10, 64)
+	if err != nil {
+		return Error("ERR value is not an integer or out of range")
+	}
+	value, err := c.db().lookupHashForWrite(key)
+	if err != nil {
+		return Error(err.Error())
+	}
+	var current int64
+	if old, ok := value.Hash[field]; ok {
+		current, err = strconv.ParseInt(old, 10, 64)
+		if err != nil {
+			return Error("ERR hash value is not an integer")
+		}
+	}
+	if (delta < 0 && current < math.MinInt64-delta) || (delta > 0 && current > math.MaxInt64-delta) {
+		return Error("ERR increment or decrement would overflow")
+	}
+	current += delta
+	c.db().hashSet(key, value, field, strconv.FormatInt(current, 10))
+	return Int(current)
+}
+
+func hincrbyfloat(c *Client, args []Value) Value {
+	key, field := args[0].Bulk, args[1].Bulk
+	delta, ok := parseLongDouble(args[2].Bulk)
+	if !ok {
+		return Error("ERR value is not a valid float")
+	}
+	value, err := c.db().lookupHashForWrite(key)
+	if err != nil {
+		return Error(err.Error())
+	}
+	current := new(big.Float)
+	if old, exists := value.Hash[field]; exists {
+		if current, ok = parseLongDouble(old); !ok {
+			return Error("ERR hash value is not a float")
+		}
+	}
+	sum, ok := addLongDouble(current, delta)
+	if !ok {
+		return Error("ERR increment would produce NaN or Infinity")
 	}
-	ans := strconv.Itoa(updateCast + 1)
-	mp[key] = RedisMapValue{Keytype: "string", Val: ans}
-	return Value{Type: "integer", Str: ans}
+	c.db().hashSet(key, value, field, formatLongDouble(sum))
+	return Bulk(value.Hash[field])
 }
 
-func info(args []Value) Value {
-	if len(os.Args) == 5 {
-		return Value{Type: "bulk", Num: 10, Bulk: "role:slave"}
+func info(c *Client, args []Value) Value {
+	sections := map[string]bool{}
+	for _, arg := range args {
+		sections[strings.ToLower(arg.Bulk)] = true
 	}
-	masterOutput := "role:master\nmaster_replid:8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb\nmaster_repl_offset:0"
-	return Value{Type: "bulk", Num: len(masterOutput), Bulk: masterOutput}
+	all := len(sections) == 0 || sections["all"] || sections["default"] || sections["everything"]
+	out := []string{}
+	if all || sections["replication"] {
+		out = append(out, infoReplication())
+	}
+	if all || sections["memory"] {
+		out = append(out, infoMemory())
+	}
+	if all || sections["stats"] {
+		out = append(out, infoStats())
+	}
+	return Bulk(strings.Join(out, "\n"))
+}
+
+func infoReplication() string {
+	if replicaof, _ := GetConfig("replicaof"); replicaof != "" {
+		return "# Replication\nrole:slave"
+	}
+	return "# Replication\nrole:master\nmaster_replid:8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb\nmaster_repl_offset:0"
+}
+
+func infoStats() string {
+	return fmt.Sprintf("# Stats\nexpired_keys:%d\nexpired_stale_perc:%d\nexpired_time_cap_reached_count:%d\nexpire_cycle_cpu_milliseconds:%d\nevicted_keys:%d\nlazyfreed_objects:%d",
+		statExpiredKeys.Load(),
+		statExpiredStalePerc.Load(),
+		statExpiredTimeCapReached.Load(),
+		time.Duration(statExpireCycleTime.Load()).Milliseconds(),
+		statEvictedKeys.Load(),
+		// Nothing is ever freed in the background, see unlink.
+		0)
+}
+
+// infoMemory reports the estimated size of the keyspace as used_memory;
+// this is what maxmemory is compared against.
+func infoMemory() string {
+	used, peak, limit := usedMemory.Load(), peakMemory.Load(), maxmemory.Load()
+	return fmt.Sprintf("# Memory\nused_memory:%d\nused_memory_human:%s\nused_memory_peak:%d\nused_memory_peak_human:%s\nmaxmemory:%d\nmaxmemory_human:%s\nmaxmemory_policy:%s",
+		used, bytesToHuman(used),
+		peak, bytesToHuman(peak),
+		limit, bytesToHuman(limit),
+		policyNames[maxmemoryPolicy.Load()])
+}
+
+func memory(c *Client, args []Value) Value {
+	subCommand := strings.ToUpper(args[0].Bulk)
+	switch subCommand {
+	case "USAGE":
+		samples := int64(maxmemorySamples.Load())
+		for i := 2; i < len(args); i += 2 {
+			if !strings.EqualFold(args[i].Bulk, "SAMPLES") || i+1 == len(args) {
+				return Error("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return Error("ERR value is not an integer or out of range")
+			}
+			if n < 0 {
+				return Error("ERR syntax error")
+			}
+			samples = n
+		}
+		key := args[1].Bulk
+		value, ok := c.db().lookupKeyNoTouch(key)
+		if !ok {
+			return NullBulk()
+		}
+		return Int(memoryUsage(key, value, int(min(samples, math.MaxInt32))))
+	case "STATS":
+		return memoryStats()
+	case "DOCTOR":
+		return Verbatim("txt", memoryDoctor())
+	}
+	return Errorf("ERR unknown subcommand '%s'. Try MEMORY HELP.", args[0].Bulk)
+}
+
+func multi(c *Client, args []Value) Value {
+	if !c.Multi {
+		c.Multi = true
+		return OK()
+	}
+	return Error("ERR MULTI calls can not be nested")
+}
+
+func exec(c *Client, args []Value) Value {
+	if !c.Multi {
+		return Error("ERR EXEC without MULTI")
+	}
+	queue := c.Queue
+	dirty := c.MultiDirty
+	c.Multi = false
+	c.MultiDirty = false
+	c.Queue = nil
+	if dirty {
+		return Error("EXECABORT Transaction discarded because of previous errors.")
+	}
+	// Writes reach the replicas wrapped in MULTI and EXEC, so that they are
+	// applied there as one transaction too.
+	output := []Value{}
+	wrapped := false
+	for _, iter := range queue {
+		if iter.Cmd.Flags&FlagWrite != 0 && !wrapped {
+			propagate(c.DB, "MULTI")
+			wrapped = true
+		}
+		output = append(output, c.execute(iter.Cmd, iter.Argv))
+	}
+	if wrapped {
+		propagate(c.DB, "EXEC")
+	}
+	return Array(output...)
+}
+
+func discard(c *Client, args []Value) Value {
+	if c.Multi {
+		c.Multi = false
+		c.MultiDirty = false
+		c.Queue = nil
+		return OK()
+	}
+	return Error("ERR DISCARD without MULTI")
 }
 
-func replconf(args []Value) Value {
-	return Value{Type: "string", Str: "OK"}
+func hello(c *Client, args []Value) Value {
+	proto := c.Proto
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0].Bulk)
+		if err != nil {
+			return Error("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != 2 && ver != 3 {
+			return Error("NOPROTO unsupported protocol version")
+		}
+		proto = ver
+	}
+	name := c.Name
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToUpper(args[i].Bulk)
+		switch {
+		case opt == "AUTH" && i+2 < len(args):
+			// No users are configured, so the default user accepts any password.
+			i += 2
+		case opt == "SETNAME" && i+1 < len(args):
+			name = args[i+1].Bulk
+			if strings.ContainsAny(name, " \n") {
+				return Error("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			i++
+		default:
+			return Errorf("ERR Syntax error in HELLO option '%s'", args[i].Bulk)
+		}
+	}
+	c.Proto = proto
+	c.Name = name
+	role := "master"
+	if replicaof, _ := GetConfig("replicaof"); replicaof != "" {
+		role = "replica"
+	}
+	return Map(
+		Bulk("server"), Bulk("redis"),
+		Bulk("version"), Bulk("7.2.0"),
+		Bulk("proto"), Int(int64(proto)),
+		Bulk("id"), Int(c.ID),
+		Bulk("mode"), Bulk("standalone"),
+		Bulk("role"), Bulk(role),
+		Bulk("modules"), Array(),
+	)
+}
+
+func replconf(c *Client, args []Value) Value {
+	return OK()
 }
 
-func psync(args []Value) Value {
+func psync(c *Client, args []Value) Value {
 	rdbFile, _ := hex.DecodeString("524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2")
-	return Value{Type: "string", Str: fmt.Sprintf("FULLRESYNC 8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb 0\r\n$%d\r\n%v", len(rdbFile), string(rdbFile)), Bulk: "rdbFlag"}
+	// The RDB payload is sent like a bulk string but without the trailing CRLF.
+	reply := SimpleString("FULLRESYNC 8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb 0").Marshall()
+	reply = append(reply, fmt.Sprintf("$%d\r\n", len(rdbFile))...)
+	reply = append(reply, rdbFile...)
+	return Raw(reply)
+}
+
+// xreadKeys locates the stream names following the STREAMS keyword; they
+// make up the first half of the remaining arguments.
+func xreadKeys(argv []Value) []int {
+	indexes := []int{}
+	for i := 1; i < len(argv); i++ {
+		if strings.ToUpper(argv[i].Bulk) != "STREAMS" {
+			continue
+		}
+		n := (len(argv) - i - 1) / 2
+		for j := 1; j <= n; j++ {
+			indexes = append(indexes, i+j)
+		}
+		break
+	}
+	return indexes
+}
+
+func command(c *Client, args []Value) Value {
+	if len(args) == 0 {
+		return commandInfo(sortedCommands())
+	}
+	subCommand := strings.ToUpper(args[0].Bulk)
+	switch subCommand {
+	case "COUNT":
+		return Int(int64(len(Commands)))
+	case "INFO":
+		if len(args) == 1 {
+			return commandInfo(sortedCommands())
+		}
+		return commandInfo(lookupCommands(args[1:]))
+	case "DOCS":
+		cmds := sortedCommands()
+		if len(args) > 1 {
+			cmds = lookupCommands(args[1:])
+		}
+		docs := []Value{}
+		for _, cmd := range cmds {
+			// Unknown names are left out of the map rather than reported as nil.
+			if cmd != nil {
+				docs = append(docs, Bulk(cmd.Name), commandDocs(cmd))
+			}
+		}
+		return Map(docs...)
+	case "GETKEYS":
+		argv := args[1:]
+		cmd, ok := LookupCommand(argv[0].Bulk)
+		if !ok {
+			return Error("ERR Invalid command specified")
+		}
+		cmd = cmd.subcommand(argv)
+		if !cmd.CheckArity(len(argv)) {
+			return Error("ERR Invalid number of arguments specified for command")
+		}
+		indexes := cmd.KeyIndexes(argv)
+		if len(indexes) == 0 {
+			return Error("ERR The command has no key arguments")
+		}
+		keys := make([]Value, len(indexes))
+		for i, idx := range indexes {
+			keys[i] = Bulk(argv[idx].Bulk)
+		}
+		return Array(keys...)
+	case "LIST":
+		var category string
+		switch len(args) {
+		case 1:
+		case 4:
+			if strings.ToUpper(args[1].Bulk) != "FILTERBY" || strings.ToUpper(args[2].Bulk) != "ACLCAT" {
+				return Error("ERR syntax error")
+			}
+			category = "@" + strings.ToLower(args[3].Bulk)
+		default:
+			return Error("ERR syntax error")
+		}
+		names := []Value{}
+		for _, cmd := range sortedCommands() {
+			if category == "" || slices.Contains(cmd.Categories, category) {
+				names = append(names, Bulk(cmd.Name))
+			}
+		}
+		return Array(names...)
+	default:
+		return Errorf("ERR unknown subcommand '%s'. Try COMMAND HELP.", args[0].Bulk)
+	}
+}
+
+func sortedCommands() []*Command {
+	cmds := make([]*Command, 0, len(Commands))
+	for _, cmd := range Commands {
+		cmds = append(cmds, cmd)
+	}
+	slices.SortFunc(cmds, func(a, b *Command) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return cmds
+}
+
+// lookupCommands resolves each name, leaving nil for unknown commands.
+func lookupCommands(names []Value) []*Command {
+	cmds := make([]*Command, len(names))
+	for i, name := range names {
+		cmds[i], _ = LookupCommand(name.Bulk)
+	}
+	return cmds
+}
+
+func commandInfo(cmds []*Command) Value {
+	infos := make([]Value, len(cmds))
+	for i, cmd := range cmds {
+		if cmd == nil {
+			infos[i] = NullArray()
+			continue
+		}
+		flags := []Value{}
+		for _, name := range cmd.Flags.Names() {
+			flags = append(flags, SimpleString(name))
+		}
+		categories := []Value{}
+		for _, category := range cmd.Categories {
+			categories = append(categories, SimpleString(category))
+		}
+		subcommands := []Value{}
+		if len(cmd.Subcommands) > 0 {
+			subcommands = commandInfo(cmd.Subcommands).Array
+		}
+		infos[i] = Array(
+			Bulk(cmd.Name),
+			Int(int64(cmd.Arity)),
+			Set(flags...),
+			Int(int64(cmd.FirstKey)),
+			Int(int64(cmd.LastKey)),
+			Int(int64(cmd.Step)),
+			Set(categories...),
+			Array(),
+			commandKeySpecs(cmd),
+			Array(subcommands...),
+		)
+	}
+	return Array(infos...)
+}
+
+// commandKeySpecs derives Redis 7 style key specifications from the legacy
+// first/last/step triple. Commands with movable keys report an unknown spec,
+// which tells clients to fall back to COMMAND GETKEYS.
+func commandKeySpecs(cmd *Command) Value {
+	if cmd.FirstKey <= 0 && cmd.GetKeys == nil {
+		return Array()
+	}
+	access := []Value{SimpleString("RO"), SimpleString("ACCESS")}
+	if cmd.Has(FlagWrite) {
+		access = []Value{SimpleString("RW"), SimpleString("UPDATE")}
+	}
+	if cmd.GetKeys != nil {
+		return Array(Map(
+			Bulk("flags"), Set(access...),
+			Bulk("begin_search"), Map(Bulk("type"), Bulk("unknown"), Bulk("spec"), Map()),
+			Bulk("find_keys"), Map(Bulk("type"), Bulk("unknown"), Bulk("spec"), Map()),
+		))
+	}
+	lastKey := cmd.LastKey
+	if lastKey >= 0 {
+		lastKey -= cmd.FirstKey
+	}
+	return Array(Map(
+		Bulk("flags"), Set(access...),
+		Bulk("begin_search"), Map(
+			Bulk("type"), Bulk("index"),
+			Bulk("spec"), Map(Bulk("index"), Int(int64(cmd.FirstKey))),
+		),
+		Bulk("find_keys"), Map(
+			Bulk("type"), Bulk("range"),
+			Bulk("spec"), Map(
+				Bulk("lastkey"), Int(int64(lastKey)),
+				Bulk("keystep"), Int(int64(cmd.Step)),
+				Bulk("limit"), Int(0),
+			),
+		),
+	))
+}
+
+func commandDocs(cmd *Command) Value {
+	doc := []Value{
+		Bulk("summary"), Bulk(cmd.Summary),
+		Bulk("since"), Bulk(cmd.Since),
+		Bulk("group"), Bulk(cmd.Group),
+		Bulk("complexity"), Bulk(cmd.Complexity),
+	}
+	if len(cmd.Subcommands) > 0 {
+		subcommands := []Value{}
+		for _, sub := range cmd.Subcommands {
+			subcommands = append(subcommands, Bulk(sub.Name), commandDocs(sub))
+		}
+		doc = append(doc, Bulk("subcommands"), Map(subcommands...))
+	}
+	return Map(doc...)
+}
+
+// parseDBIndex parses a database number given to SELECT, MOVE or SWAPDB.
+func parseDBIndex(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, errors.New("ERR value is not an integer or out of range")
+	}
+	if id < 0 || id >= len(dbs) {
+		return 0, errors.New("ERR DB index is out of range")
+	}
+	return id, nil
+}
+
+func selectDB(c *Client, args []Value) Value {
+	id, err := parseDBIndex(args[0].Bulk)
+	if err != nil {
+		return Error(err.Error())
+	}
+	c.DB = id
+	return OK()
+}
+
+func move(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	id, err := parseDBIndex(args[1].Bulk)
+	if err != nil {
+		return Error(err.Error())
+	}
+	if id == c.DB {
+		return Error("ERR source and destination objects are the same")
+	}
+	src, dst := c.db(), dbs[id]
+	value, ok := src.lookupKey(key)
+	if !ok {
+		return Int(0)
+	}
+	if _, exists := dst.lookupKey(key); exists {
+		return Int(0)
+	}
+	dst.setKey(key, value)
+	src.removeKey(key)
+	return Int(1)
+}
+
+func swapdb(c *Client, args []Value) Value {
+	first, err := strconv.Atoi(args[0].Bulk)
+	if err != nil {
+		return Error("ERR invalid first DB index")
+	}
+	second, err := strconv.Atoi(args[1].Bulk)
+	if err != nil {
+		return Error("ERR invalid second DB index")
+	}
+	if first < 0 || first >= len(dbs) || second < 0 || second >= len(dbs) {
+		return Error("ERR DB index is out of range")
+	}
+	// Clients keep pointing at the same DB, so swapping the contents makes
+	// every client connected to one database see the other one at once.
+	dbs[first].swap(dbs[second])
+	return OK()
+}
+
+// parseFlushMode validates the optional ASYNC|SYNC argument of FLUSHDB and
+// FLUSHALL and reports whether the old contents may be freed in the
+// background.
+func parseFlushMode(args []Value) (async bool, err error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	if len(args) > 1 {
+		return false, errors.New("ERR syntax error")
+	}
+	switch strings.ToUpper(args[0].Bulk) {
+	case "ASYNC":
+		return true, nil
+	case "SYNC":
+		return false, nil
+	}
+	return false, errors.New("ERR syntax error")
+}
+
+// emptyDB swaps in fresh tables for db. The old ones are unreachable
+// afterwards, so with async set they are dropped without waiting for
+// anything; otherwise they are cleared before replying, as Redis' SYNC flush
+// does.
+func emptyDB(db *DB, async bool) {
+	old := db.shards
+	db.empty()
+	if !async {
+		for _, sh := range old {
+			clear(sh.dict)
+		}
+	}
+}
+
+func flushdb(c *Client, args []Value) Value {
+	async, err := parseFlushMode(args)
+	if err != nil {
+		return Error(err.Error())
+	}
+	emptyDB(c.db(), async)
+	return OK()
+}
+
+func flushall(c *Client, args []Value) Value {
+	async, err := parseFlushMode(args)
+	if err != nil {
+		return Error(err.Error())
+	}
+	for _, db := range dbs {
+		emptyDB(db, async)
+	}
+	return OK()
+}
+
+func dbsize(c *Client, args []Value) Value {
+	return Int(int64(c.db().size()))
+}
+
+// expireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT. unit
+// scales the argument to milliseconds, and relative tells whether it is an
+// offset from now rather than a unix timestamp.
+func expireGeneric(c *Client, args []Value, name string, unit int64, relative bool) Value {
+	key := args[0].Bulk
+	when, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Error("ERR value is not an integer or out of range")
+	}
+	var nx, xx, gt, lt bool
+	for _, arg := range args[2:] {
+		switch strings.ToUpper(arg.Bulk) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return Errorf("ERR Unsupported option %s", arg.Bulk)
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return Error("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return Error("ERR GT and LT options at the same time are not compatible")
+	}
+	if when > math.MaxInt64/unit || when < math.MinInt64/unit {
+		return Errorf("ERR invalid expire time in '%s' command", name)
+	}
+	when *= unit
+	if relative {
+		now := time.Now().UnixMilli()
+		if when > math.MaxInt64-now {
+			return Errorf("ERR invalid expire time in '%s' command", name)
+		}
+		when += now
+	}
+	db := c.db()
+	value, ok := db.lookupKey(key)
+	if !ok {
+		return Int(0)
+	}
+	// A key without a TTL counts as expiring infinitely far in the future
+	// when comparing with GT and LT.
+	persistent := value.TTL.IsZero()
+	current := value.TTL.UnixMilli()
+	switch {
+	case nx && !persistent,
+		xx && persistent,
+		gt && (persistent || when <= current),
+		lt && !persistent && when >= current:
+		return Int(0)
+	}
+	deadline := time.UnixMilli(when)
+	if !time.Now().Before(deadline) {
+		db.removeKey(key)
+		propagate(db.id, "DEL", key)
+		return Int(1)
+	}
+	db.setExpire(key, deadline)
+	return Int(1)
+}
+
+func expire(c *Client, args []Value) Value {
+	return expireGeneric(c, args, "expire", 1000, true)
+}
+
+func pexpire(c *Client, args []Value) Value {
+	return expireGeneric(c, args, "pexpire", 1, true)
+}
+
+func expireat(c *Client, args []Value) Value {
+	return expireGeneric(c, args, "expireat", 1000, false)
+}
+
+func pexpireat(c *Client, args []Value) Value {
+	return expireGeneric(c, args, "pexpireat", 1, false)
+}
+
+// ttlGeneric returns the remaining time to live of a key, or -2 if it does
+// not exist and -1 if it has no TTL.
+func ttlGeneric(c *Client, key string, unit time.Duration) Value {
+	value, ok := c.db().lookupKeyNoTouch(key)
+	if !ok {
+		return Int(-2)
+	}
+	if value.TTL.IsZero() {
+		return Int(-1)
+	}
+	remaining := max(time.Until(value.TTL), 0)
+	// Like Redis, round to the nearest unit rather than truncating.
+	return Int(int64((remaining + unit/2) / unit))
+}
+
+func ttl(c *Client, args []Value) Value {
+	return ttlGeneric(c, args[0].Bulk, time.Second)
+}
+
+func pttl(c *Client, args []Value) Value {
+	return ttlGeneric(c, args[0].Bulk, time.Millisecond)
+}
+
+// expiretimeGeneric returns the absolute unix time at which a key expires,
+// with the same -2 and -1 replies as TTL.
+func expiretimeGeneric(c *Client, key string, millis bool) Value {
+	value, ok := c.db().lookupKeyNoTouch(key)
+	if !ok {
+		return Int(-2)
+	}
+	if value.TTL.IsZero() {
+		return Int(-1)
+	}
+	if millis {
+		return Int(value.TTL.UnixMilli())
+	}
+	return Int(value.TTL.Unix())
+}
+
+func expiretime(c *Client, args []Value) Value {
+	return expiretimeGeneric(c, args[0].Bulk, false)
+}
+
+func pexpiretime(c *Client, args []Value) Value {
+	return expiretimeGeneric(c, args[0].Bulk, true)
+}
+
+func persist(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	value, ok := c.db().lookupKey(key)
+	if !ok || value.TTL.IsZero() {
+		return Int(0)
+	}
+	c.db().setExpire(key, time.Time{})
+	return Int(1)
+}
+
+// checkStringLength bounds strings grown by SETRANGE and APPEND to
+// proto-max-bulk-len, as Redis does. The length is given as size plus grow
+// so that the check itself cannot overflow.
+func checkStringLength(size, grow int64) error {
+	if grow > ProtoMaxBulkLen || size > ProtoMaxBulkLen-grow {
+		return errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	return nil
+}
+
+func appendCommand(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	db := c.db()
+	value, ok, err := db.lookupKeyOfType(key, "string")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		value = RedisMapValue{Keytype: "string"}
+	}
+	if err := checkStringLength(int64(len(value.Val)), int64(len(args[1].Bulk))); err != nil {
+		return Error(err.Error())
+	}
+	value.Val += args[1].Bulk
+	// Strings that were appended to are kept raw, ready to grow in place.
+	value.Encoding = encodingRaw
+	db.setKey(key, value)
+	return Int(int64(len(value.Val)))
+}
+
+func strlen(c *Client, args []Value) Value {
+	value, _, err := c.db().lookupKeyOfType(args[0].Bulk, "string")
+	if err != nil {
+		return Error(err.Error())
+	}
+	return Int(int64(len(value.Val)))
+}
+
+func getrange(c *Client, args []Value) Value {
+	start, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Error("ERR value is not an integer or out of range")
+	}
+	end, err := strconv.ParseInt(args[2].Bulk, 10, 64)
+	if err != nil {
+		return Error("ERR value is not an integer or out of range")
+	}
+	value, _, err := c.db().lookupKeyOfType(args[0].Bulk, "string")
+	if err != nil {
+		return Error(err.Error())
+	}
+	n := int64(len(value.Val))
+	// Negative offsets count from the end; both ends are inclusive and
+	// clamped to the string.
+	if start < 0 && end < 0 && start > end {
+		return Bulk("")
+	}
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = max(n+end, 0)
+	}
+	end = min(end, n-1)
+	if n == 0 || start > end {
+		return Bulk("")
+	}
+	return Bulk(value.Val[start : end+1])
+}
+
+func setrange(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	offset, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Error("ERR value is not an integer or out of range")
+	}
+	if offset < 0 {
+		return Error("ERR offset is out of range")
+	}
+	patch := args[2].Bulk
+	db := c.db()
+	value, ok, err := db.lookupKeyOfType(key, "string")
+	if err != nil {
+		return Error(err.Error())
+	}
+	// An empty patch changes nothing, and in particular creates no key.
+	if len(patch) == 0 {
+		return Int(int64(len(value.Val)))
+	}
+	if err := checkStringLength(offset, int64(len(patch))); err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		value = RedisMapValue{Keytype: "string"}
+	}
+	buf := []byte(value.Val)
+	if end := int(offset) + len(patch); end > len(buf) {
+		// Bytes between the old end and offset are padded with zeroes.
+		buf = append(buf, make([]byte, end-len(buf))...)
+	}
+	copy(buf[offset:], patch)
+	value.Val = string(buf)
+	value.Encoding = encodingRaw
+	db.setKey(key, value)
+	return Int(int64(len(value.Val)))
+}
+
+func mget(c *Client, args []Value) Value {
+	values := make([]Value, 0, len(args))
+	db := c.db()
+	for _, arg := range args {
+		// Keys holding other types are reported as missing, not as errors.
+		value, ok := db.lookupKey(arg.Bulk)
+		if !ok || value.Keytype != "string" {
+			values = append(values, NullBulk())
+			continue
+		}
+		values = append(values, Bulk(value.Val))
+	}
+	return Array(values...)
+}
+
+// msetGeneric implements MSET and, with nx set, MSETNX, which writes nothing
+// unless none of the keys exist.
+func msetGeneric(c *Client, args []Value, name string, nx bool) Value {
+	if len(args)%2 != 0 {
+		return Errorf("ERR wrong number of arguments for '%s' command", name)
+	}
+	db := c.db()
+	if nx {
+		for i := 0; i < len(args); i += 2 {
+			if _, ok := db.lookupKey(args[i].Bulk); ok {
+				return Int(0)
+			}
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		db.setKey(args[i].Bulk, RedisMapValue{Val: args[i+1].Bulk, Keytype: "string"})
+	}
+	if nx {
+		return Int(1)
+	}
+	return OK()
+}
+
+func mset(c *Client, args []Value) Value {
+	return msetGeneric(c, args, "mset", false)
+}
+
+func msetnx(c *Client, args []Value) Value {
+	return msetGeneric(c, args, "msetnx", true)
+}
+
+func getdel(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	db := c.db()
+	value, ok, err := db.lookupKeyOfType(key, "string")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return NullBulk()
+	}
+	db.removeKey(key)
+	return Bulk(value.Val)
+}
+
+func getex(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	var persist, hasExpire bool
+	var deadline time.Time
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToUpper(args[i].Bulk)
+		switch opt {
+		case "PERSIST":
+			if hasExpire {
+				return Error("ERR syntax error")
+			}
+			persist = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasExpire || persist || i+1 == len(args) {
+				return Error("ERR syntax error")
+			}
+			i++
+			option := expireOptions[opt]
+			when, err := parseExpireTime(args[i].Bulk, option.unit, option.relative, "getex")
+			if err != nil {
+				return Error(err.Error())
+			}
+			hasExpire = true
+			deadline = when
+		default:
+			return Error("ERR syntax error")
+		}
+	}
+	db := c.db()
+	value, ok, err := db.lookupKeyOfType(key, "string")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return NullBulk()
+	}
+	switch {
+	case hasExpire && !time.Now().Before(deadline):
+		db.removeKey(key)
+		propagate(db.id, "DEL", key)
+	case hasExpire:
+		db.setExpire(key, deadline)
+	case persist:
+		db.setExpire(key, time.Time{})
+	}
+	return Bulk(value.Val)
+}
+
+func setnx(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	db := c.db()
+	if _, ok := db.lookupKey(key); ok {
+		return Int(0)
+	}
+	db.setKey(key, RedisMapValue{Val: args[1].Bulk, Keytype: "string"})
+	return Int(1)
+}
+
+func setex(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	deadline, err := parseExpireTime(args[1].Bulk, 1000, true, "setex")
+	if err != nil {
+		return Error(err.Error())
+	}
+	c.db().setKey(key, RedisMapValue{Val: args[2].Bulk, TTL: deadline, Keytype: "string"})
+	return OK()
+}
+
+// scanOptions are the filters shared by SCAN and HSCAN.
+type scanOptions struct {
+	cursor   uint64
+	count    int
+	pattern  string
+	keytype  string
+	noValues bool
+}
+
+// parseScanArgs parses "cursor [MATCH pattern] [COUNT count]" followed by
+// TYPE for SCAN or NOVALUES for HSCAN.
+func parseScanArgs(args []Value, hscan bool) (scanOptions, error) {
+	opts := scanOptions{count: 10}
+	cursor, err := strconv.ParseUint(args[0].Bulk, 10, 64)
+	if err != nil {
+		return opts, errors.New("ERR invalid cursor")
+	}
+	opts.cursor = cursor
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToUpper(args[i].Bulk)
+		hasArg := i+1 < len(args)
+		switch {
+		case opt == "MATCH" && hasArg:
+			i++
+			opts.pattern = args[i].Bulk
+		case opt == "COUNT" && hasArg:
+			i++
+			count, err := strconv.Atoi(args[i].Bulk)
+			if err != nil {
+				return opts, errors.New("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return opts, errors.New("ERR syntax error")
+			}
+			opts.count = count
+		case opt == "TYPE" && hasArg && !hscan:
+			i++
+			opts.keytype = strings.ToLower(args[i].Bulk)
+		case opt == "NOVALUES" && hscan:
+			opts.noValues = true
+		default:
+			return opts, errors.New("ERR syntax error")
+		}
+	}
+	return opts, nil
+}
+
+func (opts scanOptions) matches(name string) bool {
+	return opts.pattern == "" || opts.pattern == "*" || globMatch(opts.pattern, name)
+}
+
+func scan(c *Client, args []Value) Value {
+	opts, err := parseScanArgs(args, false)
+	if err != nil {
+		return Error(err.Error())
+	}
+	matched := []string{}
+	// Filters apply to the keys the buckets held, so a reply may hold fewer
+	// than COUNT keys, or none at all, with iteration still going on.
+	cursor := c.db().scan(opts.cursor, opts.count, c.lockShard, func(key string) {
+		if !opts.matches(key) {
+			return
+		}
+		value, ok := c.db().lookupKeyNoTouch(key)
+		if !ok || (opts.keytype != "" && value.Keytype != opts.keytype) {
+			return
+		}
+		matched = append(matched, key)
+	})
+	return Array(Bulk(strconv.FormatUint(cursor, 10)), BulkArray(matched...))
+}
+
+// hscan walks the fields of a hashtable encoded hash with the same bucket
+// cursor as SCAN. A listpack encoded hash is small, so as in Redis it is
+// returned whole, whatever the COUNT, with a cursor of 0.
+func hscan(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	opts, err := parseScanArgs(args[1:], true)
+	if err != nil {
+		return Error(err.Error())
+	}
+	value, ok, err := c.db().lookupKeyOfType(key, "hash")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return Array(Bulk("0"), Array())
+	}
+	reply := []Value{}
+	add := func(field string) {
+		if !opts.matches(field) {
+			return
+		}
+		reply = append(reply, Bulk(field))
+		if !opts.noValues {
+			reply = append(reply, Bulk(value.Hash[field]))
+		}
+	}
+	var cursor uint64
+	if value.Fields == nil {
+		for field := range value.Hash {
+			add(field)
+		}
+	} else {
+		cursor = value.Fields.scan(opts.cursor, opts.count, add)
+	}
+	return Array(Bulk(strconv.FormatUint(cursor, 10)), Array(reply...))
+}
+
+// existsGeneric counts the keys of args that exist. EXISTS only reads them,
+// while TOUCH counts as an access to them for eviction.
+func existsGeneric(c *Client, args []Value, touch bool) Value {
+	count := 0
+	db := c.db()
+	lookup := db.lookupKeyNoTouch
+	if touch {
+		lookup = db.lookupKey
+	}
+	// A key given several times is counted every time, as in Redis.
+	for _, arg := range args {
+		if _, ok := lookup(arg.Bulk); ok {
+			count++
+		}
+	}
+	return Int(int64(count))
+}
+
+func exists(c *Client, args []Value) Value {
+	return existsGeneric(c, args, false)
+}
+
+// renameGeneric implements RENAME and, with nx set, RENAMENX. The entry is
+// moved as a whole, so its type and TTL are preserved.
+func renameGeneric(c *Client, args []Value, nx bool) Value {
+	src, dst := args[0].Bulk, args[1].Bulk
+	db := c.db()
+	value, ok := db.lookupKey(src)
+	if !ok {
+		return Error("ERR no such key")
+	}
+	if src == dst {
+		if nx {
+			return Int(0)
+		}
+		return OK()
+	}
+	if _, exists := db.lookupKey(dst); exists {
+		if nx {
+			return Int(0)
+		}
+		db.removeKey(dst)
+	}
+	db.removeKey(src)
+	db.setKey(dst, value)
+	if nx {
+		return Int(1)
+	}
+	return OK()
+}
+
+func rename(c *Client, args []Value) Value {
+	return renameGeneric(c, args, false)
+}
+
+func renamenx(c *Client, args []Value) Value {
+	return renameGeneric(c, args, true)
+}
+
+func copyCommand(c *Client, args []Value) Value {
+	src, dst := args[0].Bulk, args[1].Bulk
+	target := c.DB
+	replace := false
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(args[i].Bulk)
+		switch {
+		case opt == "DB" && i+1 < len(args):
+			i++
+			id, err := parseDBIndex(args[i].Bulk)
+			if err != nil {
+				return Error(err.Error())
+			}
+			target = id
+		case opt == "REPLACE":
+			replace = true
+		default:
+			return Error("ERR syntax error")
+		}
+	}
+	if src == dst && target == c.DB {
+		return Error("ERR source and destination objects are the same")
+	}
+	srcDB, dstDB := c.db(), dbs[target]
+	value, ok := srcDB.lookupKey(src)
+	if !ok {
+		return Int(0)
+	}
+	if _, exists := dstDB.lookupKey(dst); exists {
+		if !replace {
+			return Int(0)
+		}
+		dstDB.removeKey(dst)
+	}
+	dstDB.setKey(dst, copyValue(value))
+	return Int(1)
+}
+
+// unlink behaves as DEL. Redis frees large values on a background thread
+// so that UNLINK returns at once; here a removed value is left to the
+// garbage collector whichever command removed it, so there is no freeing
+// left to defer.
+func unlink(c *Client, args []Value) Value {
+	return del(c, args)
+}
+
+func touch(c *Client, args []Value) Value {
+	return existsGeneric(c, args, true)
+}
+
+func randomkey(c *Client, args []Value) Value {
+	db := c.db()
+	// Start from a random shard; map iteration then starts at a random
+	// position too, which is all the randomness needed here.
+	start := rand.IntN(shardCount)
+	for i := range shardCount {
+		for key, value := range db.shards[(start+i)%shardCount].dict {
+			if isExpired(value.TTL) {
+				db.expireKey(key)
+				continue
+			}
+			return Bulk(key)
+		}
+	}
+	return NullBulk()
+}
+
+// pushGeneric adds the elements to the head or the tail of the list at key,
+// creating it if needed, and replies with the new length.
+func pushGeneric(c *Client, args []Value, head bool) Value {
+	key := args[0].Bulk
+	db := c.db()
+	value, ok, err := db.lookupKeyOfType(key, "list")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		value = RedisMapValue{Keytype: "list", List: lists.New(int(listMaxListpackSize.Load()))}
+		db.setKey(key, value)
+	}
+	for _, arg := range args[1:] {
+		if head {
+			value.List.PushHead(arg.Bulk)
+		} else {
+			value.List.PushTail(arg.Bulk)
+		}
+	}
+	db.listResized(key)
+	return Int(int64(value.List.Len()))
+}
+
+func lpush(c *Client, args []Value) Value {
+	return pushGeneric(c, args, true)
+}
+
+func rpush(c *Client, args []Value) Value {
+	return pushGeneric(c, args, false)
+}
+
+// listChanged records a change made in place to the list at key, and
+// deletes the key once the list is empty, as Redis never stores empty
+// lists.
+func (db *DB) listChanged(key string, value RedisMapValue) {
+	if value.List.Len() == 0 {
+		db.removeKey(key)
+		return
+	}
+	db.listResized(key)
+}
+
+// popGeneric removes elements from the head or the tail of the list at
+// key. Without a count it replies with one element, with a count with an
+// array of up to that many.
+func popGeneric(c *Client, args []Value, head bool) Value {
+	key := args[0].Bulk
+	count := int64(-1)
+	if len(args) > 2 {
+		name := "rpop"
+		if head {
+			name = "lpop"
+		}
+		return Errorf("ERR wrong number of arguments for '%s' command", name)
+	}
+	if len(args) == 2 {
+		n, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+		if err != nil || n < 0 {
+			return Error("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	db := c.db()
+	value, ok, err := db.lookupKeyOfType(key, "list")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		if count < 0 {
+			return NullBulk()
+		}
+		return NullArray()
+	}
+	pop := value.List.PopTail
+	if head {
+		pop = value.List.PopHead
+	}
+	if count < 0 {
+		v, _ := pop()
+		db.listChanged(key, value)
+		return Bulk(v)
+	}
+	popped := make([]Value, 0, min(count, int64(value.List.Len())))
+	for int64(len(popped)) < count {
+		v, ok := pop()
+		if !ok {
+			break
+		}
+		popped = append(popped, Bulk(v))
+	}
+	db.listChanged(key, value)
+	return Array(popped...)
+}
+
+func lpop(c *Client, args []Value) Value {
+	return popGeneric(c, args, true)
+}
+
+func rpop(c *Client, args []Value) Value {
+	return popGeneric(c, args, false)
+}
+
+func llen(c *Client, args []Value) Value {
+	value, ok, err := c.db().lookupKeyOfType(args[0].Bulk, "list")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return Int(0)
+	}
+	return Int(int64(value.List.Len()))
+}
+
+// listRange converts the inclusive range start..stop, where negative
+// offsets count from the end, to indexes into a list of n elements. It
+// reports false when the range is empty.
+func listRange(start, stop int64, n int) (int, int, bool) {
+	if start < 0 {
+		start = max(int64(n)+start, 0)
+	}
+	if stop < 0 {
+		stop = int64(n) + stop
+	}
+	stop = min(stop, int64(n)-1)
+	if start > stop || start >= int64(n) {
+		return 0, 0, false
+	}
+	return int(start), int(stop), true
+}
+
+func parseRange(args []Value) (int64, int64, error) {
+	start, err := strconv.ParseInt(args[0].Bulk, 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("ERR value is not an integer or out of range")
+	}
+	return start, stop, nil
+}
+
+func lrange(c *Client, args []Value) Value {
+	start, stop, err := parseRange(args[1:])
+	if err != nil {
+		return Error(err.Error())
+	}
+	value, ok, err := c.db().lookupKeyOfType(args[0].Bulk, "list")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return Array()
+	}
+	from, to, ok := listRange(start, stop, value.List.Len())
+	if !ok {
+		return Array()
+	}
+	return StreamArray(to-from+1, func(a *ArrayStream) {
+		value.List.Range(from, to, func(v string) {
+			a.Add(Bulk(v))
+		})
+	})
+}
+
+// listIndex converts an index where negative values count from the end to
+// a position in a list of n elements, reporting false if it is outside.
+func listIndex(index int64, n int) (int, bool) {
+	if index < 0 {
+		index += int64(n)
+	}
+	if index < 0 || index >= int64(n) {
+		return 0, false
+	}
+	return int(index), true
+}
+
+func lindex(c *Client, args []Value) Value {
+	index, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Error("ERR value is not an integer or out of range")
+	}
+	value, ok, err := c.db().lookupKeyOfType(args[0].Bulk, "list")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return NullBulk()
+	}
+	i, ok := listIndex(index, value.List.Len())
+	if !ok {
+		return NullBulk()
+	}
+	return Bulk(value.List.Index(i))
+}
+
+func lset(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	index, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Error("ERR value is not an integer or out of range")
+	}
+	db := c.db()
+	value, ok, err := db.lookupKeyOfType(key, "list")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return Error("ERR no such key")
+	}
+	i, ok := listIndex(index, value.List.Len())
+	if !ok {
+		return Error("ERR index out of range")
+	}
+	value.List.Set(i, args[2].Bulk)
+	db.listResized(key)
+	return OK()
+}
+
+// linsert adds an element before or after the first occurrence of a pivot
+// and replies with the new length, or -1 when the pivot is not found.
+func linsert(c *Client, args []Value) Value {
+	key, pivot, elem := args[0].Bulk, args[2].Bulk, args[3].Bulk
+	var after bool
+	switch strings.ToUpper(args[1].Bulk) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return Error("ERR syntax error")
+	}
+	db := c.db()
+	value, ok, err := db.lookupKeyOfType(key, "list")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return Int(0)
+	}
+	at := -1
+	value.List.Each(false, func(i int, v string) bool {
+		if v == pivot {
+			at = i
+			return false
+		}
+		return true
+	})
+	if at < 0 {
+		return Int(-1)
+	}
+	if after {
+		at++
+	}
+	value.List.Insert(at, elem)
+	db.listResized(key)
+	return Int(int64(value.List.Len()))
+}
+
+// lrem removes occurrences of an element: the first count of them when
+// count is positive, the last -count when it is negative, and all of them
+// when it is 0.
+func lrem(c *Client, args []Value) Value {
+	key, elem := args[0].Bulk, args[2].Bulk
+	count, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Error("ERR value is not an integer or out of range")
+	}
+	db := c.db()
+	value, ok, err := db.lookupKeyOfType(key, "list")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return Int(0)
+	}
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	limit = min(limit, int64(value.List.Len()))
+	removed := value.List.DeleteIf(func(v string) bool {
+		return v == elem
+	}, int(limit), count < 0)
+	if removed > 0 {
+		db.listChanged(key, value)
+	}
+	return Int(int64(removed))
+}
+
+func ltrim(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	start, stop, err := parseRange(args[1:])
+	if err != nil {
+		return Error(err.Error())
+	}
+	db := c.db()
+	value, ok, err := db.lookupKeyOfType(key, "list")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		return OK()
+	}
+	n := value.List.Len()
+	from, to, ok := listRange(start, stop, n)
+	if !ok {
+		value.List.Delete(0, n)
+	} else {
+		value.List.Delete(to+1, n-to-1)
+		value.List.Delete(0, from)
+	}
+	db.listChanged(key, value)
+	return OK()
+}
+
+// lpos finds the positions of an element. RANK picks which match to start
+// from, counting from the tail when negative, COUNT how many to return (0
+// for all), and MAXLEN bounds how many elements are compared.
+func lpos(c *Client, args []Value) Value {
+	key, elem := args[0].Bulk, args[1].Bulk
+	rank, count, maxlen := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return Error("ERR syntax error")
+		}
+		n, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+		if err != nil {
+			return Error("ERR value is not an integer or out of range")
+		}
+		switch strings.ToUpper(args[i].Bulk) {
+		case "RANK":
+			if n == 0 {
+				return Error("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			if n == math.MinInt64 {
+				return Error("ERR value is out of range, value must between -9223372036854775807 and 9223372036854775807")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return Error("ERR COUNT can't be negative")
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return Error("ERR MAXLEN can't be negative")
+			}
+			maxlen = n
+		default:
+			return Error("ERR syntax error")
+		}
+	}
+	value, ok, err := c.db().lookupKeyOfType(key, "list")
+	if err != nil {
+		return Error(err.Error())
+	}
+	if !ok {
+		if count < 0 {
+			return NullBulk()
+		}
+		return Array()
+	}
+	reverse := rank < 0
+	if reverse {
+		rank = -rank
+	}
+	var matches []Value
+	var compared int64
+	value.List.Each(reverse, func(i int, v string) bool {
+		if maxlen > 0 && compared == maxlen {
+			return false
+		}
+		compared++
+		if v != elem {
+			return true
+		}
+		if rank > 1 {
+			rank--
+			return true
+		}
+		matches = append(matches, Int(int64(i)))
+		return count == 0 || int64(len(matches)) < count
+	})
+	if count < 0 {
+		if len(matches) == 0 {
+			return NullBulk()
+		}
+		return matches[0]
+	}
+	return Array(matches...)
 }
@@ -0,0 +1,380 @@
+package util
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client with no connection, for driving commands
+// through Call.
+func newTestClient() *Client {
+	return &Client{
+		Proto:         2,
+		Watched:       map[string]bool{},
+		Subscriptions: map[string]bool{},
+	}
+}
+
+func call(c *Client, args ...string) Value {
+	return Call(c, BulkArray(args...).Array)
+}
+
+func TestSetrangeOffsetOverflow(t *testing.T) {
+	InitDatabases(16)
+	c := newTestClient()
+	for _, offset := range []int64{math.MaxInt64, math.MaxInt64 - 1, ProtoMaxBulkLen} {
+		reply := call(c, "SETRANGE", "k", strconv.FormatInt(offset, 10), "x")
+		if reply.Type != KindError {
+			t.Errorf("SETRANGE at offset %d: got %v, want an error", offset, reply)
+		}
+	}
+	if reply := call(c, "EXISTS", "k"); reply.Int != 0 {
+		t.Errorf("failed SETRANGE created the key")
+	}
+	if reply := call(c, "SETRANGE", "k", "2", "x"); reply.Int != 3 {
+		t.Errorf("SETRANGE k 2 x: got %v, want 3", reply)
+	}
+}
+
+func TestBlockingCommandInsideExec(t *testing.T) {
+	InitDatabases(16)
+	c := newTestClient()
+	call(c, "XADD", "st", "1-1", "a", "b")
+	call(c, "MULTI")
+	call(c, "XREAD", "block", "0", "streams", "st", "$")
+	done := make(chan Value)
+	go func() {
+		done <- call(c, "EXEC")
+	}()
+	select {
+	case reply := <-done:
+		if len(reply.Array) != 1 || reply.Array[0].Type != KindNullArray {
+			t.Fatalf("EXEC: got %v, want a null reply for XREAD", reply)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("XREAD BLOCK 0 blocked inside EXEC")
+	}
+	// Other clients are not kept out of the keyspace afterwards.
+	if reply := call(newTestClient(), "TYPE", "st"); reply.Str != "stream" {
+		t.Fatalf("TYPE st: got %v", reply)
+	}
+}
+
+// scanAll runs a SCAN family command to completion and returns the names
+// it reported, with how often each was seen. The cursor goes after the
+// arguments in cmd, and opts after the cursor; during runs between calls.
+func scanAll(t *testing.T, c *Client, during func(), cmd []string, opts ...string) map[string]int {
+	t.Helper()
+	seen := map[string]int{}
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		if calls > 10000 {
+			t.Fatal("cursor never returned to 0")
+		}
+		argv := append(append(append([]string{}, cmd...), cursor), opts...)
+		reply := call(c, append(argv, "COUNT", "10")...)
+		if reply.Type == KindError {
+			t.Fatalf("%v: %s", argv, reply.Str)
+		}
+		for _, name := range reply.Array[1].Array {
+			seen[name.Bulk]++
+		}
+		if during != nil {
+			during()
+		}
+		if cursor = reply.Array[0].Bulk; cursor == "0" {
+			return seen
+		}
+	}
+}
+
+func TestHscan(t *testing.T) {
+	InitDatabases(16)
+	c := newTestClient()
+	call(c, "HSET", "small", "a", "1", "b", "2")
+	if reply := call(c, "HSCAN", "small", "0", "COUNT", "1"); reply.Array[0].Bulk != "0" || len(reply.Array[1].Array) != 4 {
+		t.Errorf("HSCAN of a listpack hash: got %v, want all of it with cursor 0", reply)
+	}
+
+	const fields = 1000
+	for i := range fields {
+		call(c, "HSET", "big", "f"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	call(c, "COPY", "big", "copy")
+	added := 0
+	seen := scanAll(t, c, func() {
+		call(c, "HSET", "big", "new"+strconv.Itoa(added), "x")
+		added++
+	}, []string{"HSCAN", "big"}, "NOVALUES")
+	for i := range fields {
+		if seen["f"+strconv.Itoa(i)] == 0 {
+			t.Fatalf("field f%d was never returned", i)
+		}
+	}
+	if seen := scanAll(t, c, nil, []string{"HSCAN", "copy"}, "NOVALUES"); len(seen) != fields {
+		t.Errorf("HSCAN of the copy returned %d fields, want %d", len(seen), fields)
+	}
+}
+
+func TestScanInsideExec(t *testing.T) {
+	InitDatabases(16)
+	c := newTestClient()
+	for i := range 100 {
+		call(c, "SET", "k"+strconv.Itoa(i), "v")
+	}
+	if seen := scanAll(t, c, nil, []string{"SCAN"}); len(seen) != 100 {
+		t.Errorf("SCAN returned %d keys, want 100", len(seen))
+	}
+	call(c, "MULTI")
+	call(c, "SCAN", "0", "COUNT", "1000")
+	reply := call(c, "EXEC")
+	if len(reply.Array) != 1 || len(reply.Array[0].Array[1].Array) != 100 {
+		t.Errorf("SCAN inside EXEC: got %v", reply)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	InitDatabases(16)
+	var got []string
+	PropagateHook = func(db int, cmd Value) {
+		args := []string{strconv.Itoa(db)}
+		for _, arg := range cmd.Array {
+			args = append(args, arg.Bulk)
+		}
+		got = append(got, strings.Join(args, " "))
+	}
+	defer func() { PropagateHook = nil }()
+
+	c := newTestClient()
+	call(c, "MULTI")
+	call(c, "SELECT", "1")
+	call(c, "SET", "k", "v")
+	call(c, "GET", "k")
+	call(c, "EXEC")
+	call(c, "SET", "k", "w", "NX")
+	call(c, "SET", "k", "w", "XX")
+	call(c, "MULTI")
+	call(c, "SET", "discarded", "v")
+	call(c, "DISCARD")
+	call(c, "INCR", "n")
+	call(c, "GET", "n")
+	call(c, "FLUSHDB")
+	want := []string{
+		"1 MULTI",
+		"1 SET k v",
+		"1 EXEC",
+		"1 SET k w XX",
+		"1 INCR n",
+		"1 FLUSHDB",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("propagated:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSubcommandArity(t *testing.T) {
+	InitDatabases(16)
+	c := newTestClient()
+	for _, tc := range []struct {
+		args []string
+		name string
+	}{
+		{[]string{"CONFIG"}, "config"},
+		{[]string{"CONFIG", "GET"}, "config|get"},
+		{[]string{"CONFIG", "SET", "maxmemory"}, "config|set"},
+		{[]string{"OBJECT", "ENCODING"}, "object|encoding"},
+		{[]string{"OBJECT", "freq", "k", "extra"}, "object|freq"},
+		{[]string{"MEMORY", "STATS", "extra"}, "memory|stats"},
+		{[]string{"COMMAND", "COUNT", "extra"}, "command|count"},
+	} {
+		want := "ERR wrong number of arguments for '" + tc.name + "' command"
+		if reply := call(c, tc.args...); reply.Type != KindError || reply.Str != want {
+			t.Errorf("%v: got %v, want %q", tc.args, reply, want)
+		}
+	}
+	if reply := call(c, "OBJECT", "NOPE", "k"); !strings.HasPrefix(reply.Str, "ERR unknown subcommand 'NOPE'") {
+		t.Errorf("OBJECT NOPE k: got %v", reply)
+	}
+}
+
+func TestIncrbyfloat(t *testing.T) {
+	InitDatabases(16)
+	c := newTestClient()
+	for _, tc := range []struct {
+		start string
+		incrs []string
+		want  string
+	}{
+		{"", []string{"0.1", "0.2"}, "0.3"},
+		{"10.50", []string{"0.1"}, "10.6"},
+		{"5.0e3", []string{"2.0e2"}, "5200"},
+		{"", []string{"1e20"}, "100000000000000000000"},
+		{"1", []string{"-1.5"}, "-0.5"},
+		{"0.1", []string{"-0.1"}, "0"},
+		{"", []string{"1.5e-5"}, "0.000015"},
+		{"3", []string{"0"}, "3"},
+	} {
+		call(c, "DEL", "f")
+		if tc.start != "" {
+			call(c, "SET", "f", tc.start)
+		}
+		var reply Value
+		for _, incr := range tc.incrs {
+			reply = call(c, "INCRBYFLOAT", "f", incr)
+		}
+		if reply.Bulk != tc.want {
+			t.Errorf("%q incremented by %v: got %v, want %s", tc.start, tc.incrs, reply, tc.want)
+		}
+	}
+	call(c, "SET", "f", "1")
+	for _, incr := range []string{"inf", "nan", " 1", "1e5000"} {
+		if reply := call(c, "INCRBYFLOAT", "f", incr); reply.Type != KindError {
+			t.Errorf("INCRBYFLOAT f %q: got %v, want an error", incr, reply)
+		}
+	}
+	call(c, "HINCRBYFLOAT", "h", "f", "0.1")
+	if reply := call(c, "HINCRBYFLOAT", "h", "f", "0.2"); reply.Bulk != "0.3" {
+		t.Errorf("HINCRBYFLOAT 0.1 then 0.2: got %v, want 0.3", reply)
+	}
+}
+
+// positions returns the integers of an array reply.
+func positions(reply Value) []int64 {
+	ns := []int64{}
+	for _, v := range reply.Array {
+		ns = append(ns, v.Int)
+	}
+	return ns
+}
+
+func TestLpos(t *testing.T) {
+	InitDatabases(16)
+	c := newTestClient()
+	call(c, "RPUSH", "l", "a", "b", "c", "1", "2", "3", "c", "c")
+	for _, tc := range []struct {
+		args []string
+		want int64
+	}{
+		{[]string{"c"}, 2},
+		{[]string{"c", "RANK", "2"}, 6},
+		{[]string{"c", "RANK", "-1"}, 7},
+		{[]string{"c", "RANK", "-3"}, 2},
+	} {
+		if reply := call(c, append([]string{"LPOS", "l"}, tc.args...)...); reply.Type != KindInteger || reply.Int != tc.want {
+			t.Errorf("LPOS l %v: got %v, want %d", tc.args, reply, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		args []string
+		want []int64
+	}{
+		{[]string{"c", "COUNT", "2"}, []int64{2, 6}},
+		{[]string{"c", "COUNT", "0"}, []int64{2, 6, 7}},
+		{[]string{"c", "RANK", "-1", "COUNT", "2"}, []int64{7, 6}},
+		{[]string{"c", "RANK", "2", "COUNT", "0"}, []int64{6, 7}},
+		{[]string{"c", "COUNT", "0", "MAXLEN", "3"}, []int64{2}},
+		{[]string{"c", "RANK", "-1", "COUNT", "0", "MAXLEN", "2"}, []int64{7, 6}},
+		{[]string{"z", "COUNT", "0"}, []int64{}},
+	} {
+		reply := call(c, append([]string{"LPOS", "l"}, tc.args...)...)
+		if reply.Type != KindArray || !slices.Equal(positions(reply), tc.want) {
+			t.Errorf("LPOS l %v: got %v, want %v", tc.args, reply, tc.want)
+		}
+	}
+	if reply := call(c, "LPOS", "l", "z"); reply.Type != KindNullBulk {
+		t.Errorf("LPOS of a missing element: got %v, want nil", reply)
+	}
+	for _, args := range [][]string{{"RANK", "0"}, {"COUNT", "-1"}, {"MAXLEN", "-1"}} {
+		if reply := call(c, append([]string{"LPOS", "l", "c"}, args...)...); reply.Type != KindError {
+			t.Errorf("LPOS l c %v: got %v, want an error", args, reply)
+		}
+	}
+}
+
+func TestListEdits(t *testing.T) {
+	InitDatabases(16)
+	c := newTestClient()
+	lrange := func(key string) []string {
+		names := []string{}
+		for _, v := range call(c, "LRANGE", key, "0", "-1").Array {
+			names = append(names, v.Bulk)
+		}
+		return names
+	}
+
+	call(c, "RPUSH", "r", "x", "y", "x", "z", "x")
+	if reply := call(c, "LREM", "r", "-2", "x"); reply.Int != 2 {
+		t.Errorf("LREM r -2 x: got %v, want 2", reply)
+	}
+	if got := lrange("r"); !slices.Equal(got, []string{"x", "y", "z"}) {
+		t.Errorf("after LREM r -2 x: %q", got)
+	}
+
+	if reply := call(c, "LINSERT", "r", "BEFORE", "nope", "v"); reply.Int != -1 {
+		t.Errorf("LINSERT with a missing pivot: got %v, want -1", reply)
+	}
+	if reply := call(c, "LINSERT", "missing", "BEFORE", "x", "v"); reply.Int != 0 {
+		t.Errorf("LINSERT on a missing key: got %v, want 0", reply)
+	}
+	if reply := call(c, "EXISTS", "missing"); reply.Int != 0 {
+		t.Errorf("LINSERT created a missing key")
+	}
+
+	call(c, "RPUSH", "t", "a", "b")
+	if reply := call(c, "LTRIM", "t", "5", "10"); reply.Str != "OK" {
+		t.Errorf("LTRIM t 5 10: got %v", reply)
+	}
+	if reply := call(c, "EXISTS", "t"); reply.Int != 0 {
+		t.Errorf("LTRIM to an empty range kept the key")
+	}
+
+	if reply := call(c, "LPOP", "r", "0"); reply.Type != KindArray || len(reply.Array) != 0 {
+		t.Errorf("LPOP r 0: got %v, want an empty array", reply)
+	}
+	if got := lrange("r"); len(got) != 3 {
+		t.Errorf("LPOP r 0 removed elements: %q", got)
+	}
+	if reply := call(c, "LPOP", "missing"); reply.Type != KindNullBulk {
+		t.Errorf("LPOP of a missing key: got %v, want nil", reply)
+	}
+	if reply := call(c, "LPOP", "missing", "2"); reply.Type != KindNullArray {
+		t.Errorf("LPOP of a missing key with a count: got %v, want a null array", reply)
+	}
+	popped := []string{}
+	for _, v := range call(c, "RPOP", "r", "5").Array {
+		popped = append(popped, v.Bulk)
+	}
+	if !slices.Equal(popped, []string{"z", "y", "x"}) {
+		t.Errorf("RPOP r 5: got %q", popped)
+	}
+	if reply := call(c, "EXISTS", "r"); reply.Int != 0 {
+		t.Errorf("popping every element kept the key")
+	}
+}
+
+func TestReadsDoNotTouch(t *testing.T) {
+	InitDatabases(16)
+	c := newTestClient()
+	if reply := call(c, "CONFIG", "SET", "maxmemory-policy", "allkeys-lfu"); reply.Type == KindError {
+		t.Fatal(reply.Str)
+	}
+	defer call(c, "CONFIG", "SET", "maxmemory-policy", "noeviction")
+	call(c, "SET", "k", "v", "EX", "100")
+	freq := call(c, "OBJECT", "FREQ", "k").Int
+	for range 100 {
+		for _, cmd := range []string{"EXISTS", "TYPE", "TTL", "PTTL", "EXPIRETIME", "PEXPIRETIME"} {
+			call(c, cmd, "k")
+		}
+	}
+	if got := call(c, "OBJECT", "FREQ", "k").Int; got != freq {
+		t.Errorf("reading the key moved its access frequency from %d to %d", freq, got)
+	}
+	call(c, "TOUCH", "k")
+	if got := call(c, "OBJECT", "FREQ", "k").Int; got <= freq {
+		t.Errorf("TOUCH left the access frequency at %d", got)
+	}
+}
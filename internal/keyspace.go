@@ -0,0 +1,283 @@
+package util
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// shardCount is the number of lock stripes each database is split into. It
+// must be a power of two, as SCAN packs the shard into the cursor's low bits.
+const (
+	shardBits  = 4
+	shardCount = 1 << shardBits
+)
+
+// shard is one lock stripe of a database. expires mirrors the TTL of every
+// volatile key in dict, so that the active expire cycle can sample them
+// without scanning the whole keyspace, keys indexes the names for SCAN, and
+// used is the estimated memory of the entries.
+type shard struct {
+	mu      sync.Mutex
+	dict    map[string]RedisMapValue
+	expires map[string]time.Time
+	keys    *scanTable
+	used    int64
+}
+
+func newShard() *shard {
+	return &shard{
+		dict:    map[string]RedisMapValue{},
+		expires: map[string]time.Time{},
+		keys:    newScanTable(),
+	}
+}
+
+// DB is one of the numbered logical databases chosen with SELECT. Its keys
+// are spread over shards by hash.
+type DB struct {
+	id     int
+	shards [shardCount]*shard
+}
+
+func newDB(id int) *DB {
+	db := &DB{id: id}
+	db.empty()
+	return db
+}
+
+func shardIndex(key string) int {
+	return int(hashKey(key) & (shardCount - 1))
+}
+
+func (db *DB) shard(key string) *shard {
+	return db.shards[shardIndex(key)]
+}
+
+// empty drops every key of db. Callers hold keyspaceMu exclusively.
+func (db *DB) empty() {
+	dirty.Add(1)
+	for i, sh := range db.shards {
+		if sh != nil {
+			addUsedMemory(-sh.used)
+		}
+		db.shards[i] = newShard()
+	}
+}
+
+// swap exchanges the contents of two databases, leaving their numbers.
+// Callers hold keyspaceMu exclusively.
+func (db *DB) swap(other *DB) {
+	dirty.Add(1)
+	db.shards, other.shards = other.shards, db.shards
+}
+
+// dbs holds every logical database.
+//
+// Locking is two-level. A command on known keys holds keyspaceMu shared and
+// the mutexes of the shards its keys live in, taken in shard order so that
+// multi-key commands cannot deadlock. Commands that span whole databases,
+// and EXEC, hold keyspaceMu exclusively instead, which keeps every other
+// command out. Call takes the locks before running a handler, so handlers
+// themselves do not lock unless they block.
+var dbs = newDatabases(16)
+var keyspaceMu = sync.RWMutex{}
+
+// dirty counts the changes made to the keyspace, like Redis' server.dirty.
+// Call reads it around a write command to tell whether the command changed
+// anything and has to reach the replicas.
+var dirty atomic.Int64
+
+func newDatabases(n int) []*DB {
+	databases := make([]*DB, n)
+	for i := range databases {
+		databases[i] = newDB(i)
+	}
+	return databases
+}
+
+// InitDatabases replaces the keyspace with n empty databases. It is meant
+// to be called once at startup, before any client connects.
+func InitDatabases(n int) {
+	keyspaceMu.Lock()
+	dbs = newDatabases(n)
+	keyspaceMu.Unlock()
+}
+
+// db returns the database currently selected by c.
+func (c *Client) db() *DB {
+	return dbs[c.DB]
+}
+
+// lockKeys locks the shards holding keys in db and returns the function
+// releasing them.
+func lockKeys(db *DB, keys []string) func() {
+	var held [shardCount]bool
+	for _, key := range keys {
+		held[shardIndex(key)] = true
+	}
+	keyspaceMu.RLock()
+	for i, h := range held {
+		if h {
+			db.shards[i].mu.Lock()
+		}
+	}
+	return func() {
+		for i, h := range held {
+			if h {
+				db.shards[i].mu.Unlock()
+			}
+		}
+		keyspaceMu.RUnlock()
+	}
+}
+
+// lockKeyspace locks every database exclusively and returns the function
+// releasing it.
+func lockKeyspace() func() {
+	keyspaceMu.Lock()
+	return keyspaceMu.Unlock
+}
+
+// lookupKey returns the entry stored at key, deleting it first if it has
+// expired, and records the access for eviction. Callers must hold the lock
+// of key's shard.
+func (db *DB) lookupKey(key string) (RedisMapValue, bool) {
+	value, ok := db.lookupKeyNoTouch(key)
+	if ok {
+		value.LRU = touchedLRU(value.LRU)
+		db.shard(key).dict[key] = value
+	}
+	return value, ok
+}
+
+// lookupKeyNoTouch is lookupKey for introspection, which must not count as
+// an access.
+func (db *DB) lookupKeyNoTouch(key string) (RedisMapValue, bool) {
+	value, ok := db.shard(key).dict[key]
+	if !ok {
+		return RedisMapValue{}, false
+	}
+	if isExpired(value.TTL) {
+		db.expireKey(key)
+		return RedisMapValue{}, false
+	}
+	return value, true
+}
+
+// lookupKeyOfType is lookupKey for commands that only operate on one kind
+// of value. It fails with ErrWrongType when key holds anything else.
+func (db *DB) lookupKeyOfType(key, keytype string) (RedisMapValue, bool, error) {
+	value, ok := db.lookupKey(key)
+	if ok && value.Keytype != keytype {
+		return RedisMapValue{}, false, ErrWrongType
+	}
+	return value, ok, nil
+}
+
+// setKey stores value at key, replacing any previous entry and its TTL.
+// The size of strings is always recomputed; collections keep the size they
+// carry, which is only computed here when they were just built. Likewise a
+// value without an encoding gets the one Redis would pick for it.
+func (db *DB) setKey(key string, value RedisMapValue) {
+	dirty.Add(1)
+	sh := db.shard(key)
+	old, exists := sh.dict[key]
+	if exists {
+		sh.used -= entrySize(key, old)
+		addUsedMemory(-entrySize(key, old))
+	} else {
+		sh.keys.add(key)
+	}
+	if value.Keytype == "string" || value.Size == 0 {
+		value.Size = valueSize(value)
+	}
+	if value.Encoding == "" {
+		value.Encoding = valueEncoding(value)
+	}
+	// Overwriting a key keeps its access frequency, as in Redis.
+	if exists && isLFUPolicy(maxmemoryPolicy.Load()) {
+		value.LRU = old.LRU
+	} else {
+		value.LRU = initialLRU()
+	}
+	sh.used += entrySize(key, value)
+	addUsedMemory(entrySize(key, value))
+	sh.dict[key] = value
+	if value.TTL.IsZero() {
+		delete(sh.expires, key)
+	} else {
+		sh.expires[key] = value.TTL
+	}
+}
+
+// setExpire changes the TTL of an existing key; a zero when makes it
+// persistent.
+func (db *DB) setExpire(key string, when time.Time) {
+	dirty.Add(1)
+	sh := db.shard(key)
+	value := sh.dict[key]
+	value.TTL = when
+	sh.dict[key] = value
+	if when.IsZero() {
+		delete(sh.expires, key)
+	} else {
+		sh.expires[key] = when
+	}
+}
+
+// removeKey drops key from db whether or not it has expired.
+func (db *DB) removeKey(key string) {
+	sh := db.shard(key)
+	old, exists := sh.dict[key]
+	if !exists {
+		return
+	}
+	dirty.Add(1)
+	sh.used -= entrySize(key, old)
+	addUsedMemory(-entrySize(key, old))
+	sh.keys.remove(key)
+	delete(sh.dict, key)
+	delete(sh.expires, key)
+}
+
+// deleteKey removes key and reports whether a live entry was removed.
+func (db *DB) deleteKey(key string) bool {
+	if _, ok := db.lookupKey(key); !ok {
+		return false
+	}
+	db.removeKey(key)
+	return true
+}
+
+// size counts the keys in db, including expired ones that have not been
+// reclaimed yet, as Redis' DBSIZE does. Callers hold keyspaceMu
+// exclusively.
+func (db *DB) size() int {
+	n := 0
+	for _, sh := range db.shards {
+		n += len(sh.dict)
+	}
+	return n
+}
+
+// copyValue returns a deep copy of value, so that COPY leaves the source
+// and the destination independent.
+func copyValue(value RedisMapValue) RedisMapValue {
+	switch value.Keytype {
+	case "hash":
+		value.Hash = maps.Clone(value.Hash)
+		if value.Fields != nil {
+			value.Fields = value.Fields.copy()
+		}
+	case "list":
+		value.List = value.List.Copy()
+	case "stream":
+		value.Stream = value.Stream.Copy()
+	}
+	return value
+}
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentCommands drives Call from many goroutines at once, mixing
+// single-key, multi-key and whole-keyspace commands, and checks that no
+// update is lost. It is meant to be run with -race.
+func TestConcurrentCommands(t *testing.T) {
+	InitDatabases(16)
+	// Keys left behind by earlier tests are still counted.
+	base := usedMemory.Load()
+	const (
+		workers = 16
+		rounds  = 300
+	)
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				activeExpireCycle()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := newTestClient()
+			key := func() string {
+				return "k" + strconv.Itoa(rand.IntN(32))
+			}
+			for i := range rounds {
+				// Database 0 only sees commands whose effect is checked below.
+				call(c, "SELECT", "0")
+				call(c, "INCR", "counter")
+				call(c, "HINCRBY", "hash", "f"+strconv.Itoa(w%4), "1")
+				call(c, "RPUSH", "list", "x")
+				call(c, "MULTI")
+				call(c, "INCR", "counter")
+				call(c, "HINCRBY", "hash", "f"+strconv.Itoa(w%4), "1")
+				call(c, "EXEC")
+
+				// Databases 1 to 3 take everything else.
+				call(c, "SELECT", strconv.Itoa(1+rand.IntN(3)))
+				switch rand.IntN(14) {
+				case 0:
+					call(c, "SET", key(), strconv.Itoa(i), "PX", strconv.Itoa(1+rand.IntN(5)))
+				case 1:
+					call(c, "MSET", key(), "a", key(), "b", key(), "c")
+				case 2:
+					call(c, "MGET", key(), key(), key())
+				case 3:
+					call(c, "RENAME", key(), key())
+				case 4:
+					call(c, "COPY", key(), key(), "DB", strconv.Itoa(1+rand.IntN(3)), "REPLACE")
+				case 5:
+					call(c, "DEL", key(), key())
+				case 6:
+					call(c, "UNLINK", key())
+				case 7:
+					call(c, "KEYS", "k1*")
+				case 8:
+					call(c, "SCAN", "0", "COUNT", "5")
+				case 9:
+					call(c, "DBSIZE")
+				case 10:
+					call(c, "SWAPDB", "2", "3")
+				case 11:
+					call(c, "FLUSHDB")
+				case 12:
+					call(c, "LPUSH", key(), "a", "b")
+					call(c, "LPOP", key())
+				case 13:
+					call(c, "HSET", key(), "f", "v")
+					call(c, "MOVE", key(), strconv.Itoa(1+rand.IntN(3)))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	background.Wait()
+
+	c := newTestClient()
+	want := strconv.Itoa(2 * workers * rounds)
+	if got := call(c, "GET", "counter").Bulk; got != want {
+		t.Errorf("counter = %s, want %s", got, want)
+	}
+	total := 0
+	fields := call(c, "HGETALL", "hash").Array
+	for i := 1; i < len(fields); i += 2 {
+		n, _ := strconv.Atoi(fields[i].Bulk)
+		total += n
+	}
+	if total != 2*workers*rounds {
+		t.Errorf("hash fields sum to %d, want %d", total, 2*workers*rounds)
+	}
+	if got := call(c, "LLEN", "list").Int; got != workers*rounds {
+		t.Errorf("LLEN list = %d, want %d", got, workers*rounds)
+	}
+
+	// The memory accounting stays consistent with the keyspace.
+	call(c, "FLUSHALL")
+	if used := usedMemory.Load() - base; used != 0 {
+		t.Errorf("FLUSHALL left %d bytes accounted", used)
+	}
+}
@@ -0,0 +1,418 @@
+package lists
+
+import "slices"
+
+// List is a quicklist: a doubly linked list of nodes that each pack a run of
+// elements, like Redis' quicklist of listpacks. Chunking keeps the overhead
+// per element low, pushes and pops at either end stay O(1), and an insert
+// in the middle only moves the elements of one node.
+type List struct {
+	head  *node
+	tail  *node
+	len   int
+	bytes int
+	nodes int
+	fill  int
+}
+
+type node struct {
+	prev  *node
+	next  *node
+	elems []string
+	bytes int
+}
+
+// sizeLimits are the node sizes, in bytes, selected by a negative fill as
+// in list-max-listpack-size: -1 for 4 KB up to -5 for 64 KB.
+var sizeLimits = [...]int{4096, 8192, 16384, 32768, 65536}
+
+// safetyLimit caps the bytes of a node bounded by element count, like
+// Redis' SIZE_SAFETY_LIMIT.
+const safetyLimit = 8192
+
+// New returns an empty list whose nodes are bounded by fill: a positive
+// fill is a number of elements per node, a negative one a size class.
+func New(fill int) *List {
+	return &List{fill: fill}
+}
+
+// Len returns the number of elements in l.
+func (l *List) Len() int {
+	return l.len
+}
+
+// Bytes returns the total length of the elements of l.
+func (l *List) Bytes() int {
+	return l.bytes
+}
+
+// Nodes returns the number of nodes l is split into.
+func (l *List) Nodes() int {
+	return l.nodes
+}
+
+// fits reports whether a node of count elements totalling size bytes is
+// within the fill of l.
+func (l *List) fits(count, size int) bool {
+	if l.fill < 0 {
+		return size <= sizeLimits[min(-l.fill, len(sizeLimits))-1]
+	}
+	return count <= max(l.fill, 1) && size <= safetyLimit
+}
+
+// accepts reports whether v can be added to n. Every node accepts its first
+// element, however large.
+func (l *List) accepts(n *node, v string) bool {
+	return n != nil && l.fits(len(n.elems)+1, n.bytes+len(v))
+}
+
+// insertNode links n after prev, or at the head when prev is nil.
+func (l *List) insertNode(prev, n *node) {
+	n.prev = prev
+	if prev == nil {
+		n.next = l.head
+		l.head = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		l.tail = n
+	} else {
+		n.next.prev = n
+	}
+	l.nodes++
+}
+
+func (l *List) unlinkNode(n *node) {
+	if n.prev == nil {
+		l.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		l.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	l.nodes--
+}
+
+// PushHead adds v at the head of l.
+func (l *List) PushHead(v string) {
+	if !l.accepts(l.head, v) {
+		l.insertNode(nil, &node{})
+	}
+	n := l.head
+	n.elems = append(n.elems, "")
+	copy(n.elems[1:], n.elems)
+	n.elems[0] = v
+	n.bytes += len(v)
+	l.len++
+	l.bytes += len(v)
+}
+
+// PushTail adds v at the tail of l.
+func (l *List) PushTail(v string) {
+	if !l.accepts(l.tail, v) {
+		l.insertNode(l.tail, &node{})
+	}
+	l.tail.elems = append(l.tail.elems, v)
+	l.tail.bytes += len(v)
+	l.len++
+	l.bytes += len(v)
+}
+
+// PopHead removes and returns the first element of l.
+func (l *List) PopHead() (string, bool) {
+	if l.len == 0 {
+		return "", false
+	}
+	v := l.head.elems[0]
+	l.Delete(0, 1)
+	return v, true
+}
+
+// PopTail removes and returns the last element of l.
+func (l *List) PopTail() (string, bool) {
+	if l.len == 0 {
+		return "", false
+	}
+	v := l.tail.elems[len(l.tail.elems)-1]
+	l.Delete(l.len-1, 1)
+	return v, true
+}
+
+// locate finds the node holding element i, with 0 <= i < Len, walking from
+// the nearer end, and returns it with the offset of i inside it.
+func (l *List) locate(i int) (*node, int) {
+	if i < l.len/2 {
+		n := l.head
+		for i >= len(n.elems) {
+			i -= len(n.elems)
+			n = n.next
+		}
+		return n, i
+	}
+	i = l.len - 1 - i
+	n := l.tail
+	for i >= len(n.elems) {
+		i -= len(n.elems)
+		n = n.prev
+	}
+	return n, len(n.elems) - 1 - i
+}
+
+// Index returns element i, with 0 <= i < Len.
+func (l *List) Index(i int) string {
+	n, off := l.locate(i)
+	return n.elems[off]
+}
+
+// Set replaces element i, with 0 <= i < Len.
+func (l *List) Set(i int, v string) {
+	n, off := l.locate(i)
+	delta := len(v) - len(n.elems[off])
+	n.elems[off] = v
+	n.bytes += delta
+	l.bytes += delta
+}
+
+// Insert adds v so that it becomes element i, with 0 <= i <= Len. When the
+// node in the way is full, v goes to a neighbour with room or the node is
+// split around it.
+func (l *List) Insert(i int, v string) {
+	switch i {
+	case 0:
+		l.PushHead(v)
+		return
+	case l.len:
+		l.PushTail(v)
+		return
+	}
+	n, off := l.locate(i)
+	switch {
+	case l.accepts(n, v):
+	case off == 0 && l.accepts(n.prev, v):
+		n, off = n.prev, len(n.prev.elems)
+	default:
+		// Split n so that v goes between its two halves: at the end of the
+		// first or the start of the second if either has room, or else in
+		// a node of its own.
+		prev := n.prev
+		if off > 0 {
+			rest := l.split(n, off)
+			if l.accepts(n, v) {
+				break
+			}
+			if l.accepts(rest, v) {
+				n, off = rest, 0
+				break
+			}
+			prev = n
+		}
+		n, off = &node{}, 0
+		l.insertNode(prev, n)
+	}
+	n.elems = append(n.elems, "")
+	copy(n.elems[off+1:], n.elems[off:])
+	n.elems[off] = v
+	n.bytes += len(v)
+	l.len++
+	l.bytes += len(v)
+}
+
+// split moves the elements of n from off on to a new node that follows it,
+// and returns that node.
+func (l *List) split(n *node, off int) *node {
+	rest := &node{elems: append([]string(nil), n.elems[off:]...)}
+	for _, e := range rest.elems {
+		rest.bytes += len(e)
+	}
+	clear(n.elems[off:])
+	n.elems = n.elems[:off]
+	n.bytes -= rest.bytes
+	l.insertNode(n, rest)
+	return rest
+}
+
+// Delete removes count elements starting at element start, with
+// 0 <= start and start+count <= Len.
+func (l *List) Delete(start, count int) {
+	if count <= 0 {
+		return
+	}
+	// Nodes emptied on the way are unlinked; only the first and the last
+	// node may keep elements, and those are merged with their neighbours
+	// once done.
+	var first, last *node
+	n, off := l.locate(start)
+	for count > 0 {
+		k := min(count, len(n.elems)-off)
+		for _, e := range n.elems[off : off+k] {
+			n.bytes -= len(e)
+			l.bytes -= len(e)
+		}
+		n.elems = append(n.elems[:off], n.elems[off+k:]...)
+		clear(n.elems[len(n.elems):cap(n.elems)])
+		l.len -= k
+		count -= k
+		next := n.next
+		switch {
+		case len(n.elems) == 0:
+			l.unlinkNode(n)
+		case first == nil:
+			first = n
+		default:
+			last = n
+		}
+		n, off = next, 0
+	}
+	if last != nil {
+		l.settle(last)
+	}
+	if first != nil {
+		l.settle(first)
+	}
+}
+
+// DeleteIf removes the elements for which match returns true, stopping
+// after limit of them unless limit is 0, and reports how many it removed.
+// With fromTail the elements are visited from the tail.
+func (l *List) DeleteIf(match func(v string) bool, limit int, fromTail bool) int {
+	removed := 0
+	n := l.head
+	if fromTail {
+		n = l.tail
+	}
+	for n != nil && (limit == 0 || removed < limit) {
+		kept := make([]string, 0, len(n.elems))
+		visit := func(e string) {
+			if (limit == 0 || removed < limit) && match(e) {
+				removed++
+				n.bytes -= len(e)
+				l.bytes -= len(e)
+				l.len--
+				return
+			}
+			kept = append(kept, e)
+		}
+		if fromTail {
+			for i := len(n.elems) - 1; i >= 0; i-- {
+				visit(n.elems[i])
+			}
+			slices.Reverse(kept)
+		} else {
+			for _, e := range n.elems {
+				visit(e)
+			}
+		}
+		n.elems = kept
+		next := n.next
+		if fromTail {
+			next = n.prev
+		}
+		if len(n.elems) == 0 {
+			l.unlinkNode(n)
+		}
+		n = next
+	}
+	if removed > 0 {
+		l.compact()
+	}
+	return removed
+}
+
+// settle unlinks n once it is empty, and otherwise merges it with a
+// neighbour when both fit in one node, so that deletions do not leave the
+// list fragmented into many small nodes.
+func (l *List) settle(n *node) {
+	if len(n.elems) == 0 {
+		l.unlinkNode(n)
+		return
+	}
+	if next := n.next; next != nil && l.fits(len(n.elems)+len(next.elems), n.bytes+next.bytes) {
+		l.merge(n, next)
+	}
+	if prev := n.prev; prev != nil && l.fits(len(prev.elems)+len(n.elems), prev.bytes+n.bytes) {
+		l.merge(prev, n)
+	}
+}
+
+// compact merges every pair of adjacent nodes that fit in one, after a
+// deletion that may have shrunk nodes anywhere in the list.
+func (l *List) compact() {
+	for n := l.head; n != nil && n.next != nil; {
+		if next := n.next; l.fits(len(n.elems)+len(next.elems), n.bytes+next.bytes) {
+			l.merge(n, next)
+		} else {
+			n = next
+		}
+	}
+}
+
+// merge moves the elements of next into n, which precedes it.
+func (l *List) merge(n, next *node) {
+	n.elems = append(n.elems, next.elems...)
+	n.bytes += next.bytes
+	l.unlinkNode(next)
+}
+
+// Range calls fn for elements start to end inclusive, with
+// 0 <= start <= end < Len, in order.
+func (l *List) Range(start, end int, fn func(v string)) {
+	n, off := l.locate(start)
+	for i := start; i <= end; i++ {
+		if off == len(n.elems) {
+			n, off = n.next, 0
+		}
+		fn(n.elems[off])
+		off++
+	}
+}
+
+// Each calls fn with the index and value of every element, from the head
+// or with reverse from the tail, until fn returns false.
+func (l *List) Each(reverse bool, fn func(i int, v string) bool) {
+	if reverse {
+		i := l.len - 1
+		for n := l.tail; n != nil; n = n.prev {
+			for j := len(n.elems) - 1; j >= 0; j-- {
+				if !fn(i, n.elems[j]) {
+					return
+				}
+				i--
+			}
+		}
+		return
+	}
+	i := 0
+	for n := l.head; n != nil; n = n.next {
+		for _, e := range n.elems {
+			if !fn(i, e) {
+				return
+			}
+			i++
+		}
+	}
+}
+
+// Clear removes every element of l.
+func (l *List) Clear() {
+	for n := l.head; n != nil; n = n.next {
+		clear(n.elems)
+	}
+	l.head, l.tail = nil, nil
+	l.len, l.bytes, l.nodes = 0, 0, 0
+}
+
+// Copy returns a deep copy of l.
+func (l *List) Copy() *List {
+	dup := New(l.fill)
+	for n := l.head; n != nil; n = n.next {
+		dup.insertNode(dup.tail, &node{elems: append([]string(nil), n.elems...), bytes: n.bytes})
+	}
+	dup.len = l.len
+	dup.bytes = l.bytes
+	return dup
+}
@@ -0,0 +1,255 @@
+package lists
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fills covers counts per node, no limit worth speaking of, and size classes.
+var fills = []int{1, 2, 0, -1, -5}
+
+// checkList compares l with want and checks the invariants of its nodes.
+// Once resized is set, elements were replaced in place, which may leave a
+// node over its fill, as in Redis.
+func checkList(t *testing.T, l *List, want []string, resized bool) {
+	t.Helper()
+	if l.Len() != len(want) {
+		t.Fatalf("Len = %d, want %d", l.Len(), len(want))
+	}
+	got := []string{}
+	l.Each(false, func(i int, v string) bool {
+		if i != len(got) {
+			t.Fatalf("Each passed index %d for element %d", i, len(got))
+		}
+		got = append(got, v)
+		return true
+	})
+	if !slices.Equal(got, want) {
+		t.Fatalf("elements = %q, want %q", got, want)
+	}
+	reversed := []string{}
+	l.Each(true, func(i int, v string) bool {
+		if i != len(want)-1-len(reversed) {
+			t.Fatalf("reverse Each passed index %d", i)
+		}
+		reversed = append(reversed, v)
+		return true
+	})
+	slices.Reverse(reversed)
+	if !slices.Equal(reversed, want) {
+		t.Fatalf("reverse elements = %q, want %q", reversed, want)
+	}
+
+	nodes, bytes := 0, 0
+	var prev *node
+	for n := l.head; n != nil; prev, n = n, n.next {
+		nodes++
+		if n.prev != prev {
+			t.Fatalf("node %d: broken prev link", nodes)
+		}
+		if len(n.elems) == 0 {
+			t.Fatalf("node %d is empty", nodes)
+		}
+		size := 0
+		for _, e := range n.elems {
+			size += len(e)
+		}
+		if size != n.bytes {
+			t.Fatalf("node %d: bytes = %d, want %d", nodes, n.bytes, size)
+		}
+		if !resized && len(n.elems) > 1 && !l.fits(len(n.elems), n.bytes) {
+			t.Fatalf("node %d of %d elements and %d bytes exceeds fill %d", nodes, len(n.elems), n.bytes, l.fill)
+		}
+		bytes += size
+	}
+	if l.tail != prev {
+		t.Fatalf("tail is not the last node")
+	}
+	if nodes != l.Nodes() || bytes != l.Bytes() {
+		t.Fatalf("Nodes, Bytes = %d, %d, want %d, %d", l.Nodes(), l.Bytes(), nodes, bytes)
+	}
+}
+
+// runOps applies the operations encoded in ops to a list of the given fill
+// and to a slice, comparing them after every step.
+func runOps(t *testing.T, fill int, ops []byte) {
+	l := New(fill)
+	var want []string
+	resized := false
+	next := func() int {
+		if len(ops) == 0 {
+			return 0
+		}
+		b := ops[0]
+		ops = ops[1:]
+		return int(b)
+	}
+	// Elements are mostly small, sometimes larger than any node may be.
+	value := func() string {
+		n := next()
+		switch {
+		case n < 200:
+			return strconv.Itoa(n)
+		case n < 230:
+			return strings.Repeat("m", n*20)
+		default:
+			return strings.Repeat("L", 70000+n)
+		}
+	}
+	for step := 0; len(ops) > 0; step++ {
+		op := next() % 12
+		switch op {
+		case 0:
+			v := value()
+			l.PushHead(v)
+			want = slices.Insert(want, 0, v)
+		case 1:
+			v := value()
+			l.PushTail(v)
+			want = append(want, v)
+		case 2:
+			v, ok := l.PopHead()
+			if ok != (len(want) > 0) || (ok && v != want[0]) {
+				t.Fatalf("step %d: PopHead = %q, %v", step, v, ok)
+			}
+			if ok {
+				want = want[1:]
+			}
+		case 3:
+			v, ok := l.PopTail()
+			if ok != (len(want) > 0) || (ok && v != want[len(want)-1]) {
+				t.Fatalf("step %d: PopTail = %q, %v", step, v, ok)
+			}
+			if ok {
+				want = want[:len(want)-1]
+			}
+		case 4:
+			i := next() % (len(want) + 1)
+			v := value()
+			l.Insert(i, v)
+			want = slices.Insert(want, i, v)
+		case 5:
+			if len(want) == 0 {
+				continue
+			}
+			start := next() % len(want)
+			count := next() % (len(want) - start + 1)
+			l.Delete(start, count)
+			want = slices.Delete(want, start, start+count)
+		case 6:
+			digit := strconv.Itoa(next() % 10)
+			limit := next() % 4
+			fromTail := next()%2 == 1
+			match := func(v string) bool { return strings.HasSuffix(v, digit) }
+			removed := l.DeleteIf(match, limit, fromTail)
+			kept, count := slices.Clone(want), 0
+			if fromTail {
+				slices.Reverse(kept)
+			}
+			kept = slices.DeleteFunc(kept, func(v string) bool {
+				if (limit == 0 || count < limit) && match(v) {
+					count++
+					return true
+				}
+				return false
+			})
+			if fromTail {
+				slices.Reverse(kept)
+			}
+			if removed != count {
+				t.Fatalf("step %d: DeleteIf removed %d, want %d", step, removed, count)
+			}
+			want = kept
+		case 7:
+			if len(want) == 0 {
+				continue
+			}
+			i := next() % len(want)
+			v := value()
+			l.Set(i, v)
+			want[i] = v
+			resized = true
+		case 8:
+			if len(want) == 0 {
+				continue
+			}
+			i := next() % len(want)
+			if got := l.Index(i); got != want[i] {
+				t.Fatalf("step %d: Index(%d) = %q, want %q", step, i, got, want[i])
+			}
+		case 9:
+			if len(want) == 0 {
+				continue
+			}
+			start := next() % len(want)
+			end := start + next()%(len(want)-start)
+			got := []string{}
+			l.Range(start, end, func(v string) { got = append(got, v) })
+			if !slices.Equal(got, want[start:end+1]) {
+				t.Fatalf("step %d: Range(%d, %d) = %q, want %q", step, start, end, got, want[start:end+1])
+			}
+		case 10:
+			// The copy is checked, then changed without the original
+			// noticing.
+			dup := l.Copy()
+			checkList(t, dup, want, resized)
+			dup.PushHead("copy")
+			if len(want) > 0 {
+				dup.Set(dup.Len()-1, "copy")
+				dup.Delete(1, dup.Len()/2)
+			}
+		case 11:
+			if next()%8 == 0 {
+				l.Clear()
+				want = nil
+				resized = false
+			}
+		}
+		checkList(t, l, want, resized)
+	}
+}
+
+func TestListMatchesSlice(t *testing.T) {
+	for _, fill := range fills {
+		t.Run("fill "+strconv.Itoa(fill), func(t *testing.T) {
+			rng := rand.New(rand.NewPCG(uint64(fill+10), 1))
+			for range 50 {
+				ops := make([]byte, 3000)
+				for i := range ops {
+					ops[i] = byte(rng.UintN(256))
+				}
+				runOps(t, fill, ops)
+			}
+		})
+	}
+}
+
+func TestInsertSplitsFullNode(t *testing.T) {
+	l := New(4)
+	want := []string{}
+	for i := range 8 {
+		l.PushTail(strconv.Itoa(i))
+		want = append(want, strconv.Itoa(i))
+	}
+	// Node boundaries are 0-3 and 4-7: the middle of a full node, the
+	// boundary itself, and an element too large for any node.
+	for _, tc := range []struct {
+		i int
+		v string
+	}{{2, "a"}, {5, "b"}, {0, strings.Repeat("x", safetyLimit+1)}, {3, strings.Repeat("y", safetyLimit+1)}} {
+		l.Insert(tc.i, tc.v)
+		want = slices.Insert(want, tc.i, tc.v)
+		checkList(t, l, want, false)
+	}
+}
+
+func FuzzList(f *testing.F) {
+	f.Add(0, []byte{1, 5, 1, 6, 4, 0, 7, 5, 0, 2})
+	f.Add(-1, []byte{1, 240, 1, 250, 4, 1, 3, 6, 5, 0, 2})
+	f.Fuzz(func(t *testing.T, fill int, ops []byte) {
+		runOps(t, fills[uint(fill)%uint(len(fills))], ops)
+	})
+}
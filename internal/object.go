@@ -0,0 +1,233 @@
+package util
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/lists"
+)
+
+// Encodings reported by OBJECT ENCODING. They name the representation Redis
+// would choose for a value, following the same rules, so that tools tuning
+// a Redis deployment can be pointed at this server too.
+const (
+	encodingInt       = "int"
+	encodingEmbstr    = "embstr"
+	encodingRaw       = "raw"
+	encodingListpack  = "listpack"
+	encodingHashtable = "hashtable"
+	encodingQuicklist = "quicklist"
+	encodingStream    = "stream"
+)
+
+// embstrSizeLimit is the longest string that is embstr encoded, as Redis'
+// OBJ_ENCODING_EMBSTR_SIZE_LIMIT.
+const embstrSizeLimit = 44
+
+// Limits under which a hash stays listpack encoded, set by
+// hash-max-listpack-entries and hash-max-listpack-value.
+var (
+	hashMaxListpackEntries atomic.Int64
+	hashMaxListpackValue   atomic.Int64
+)
+
+// listMaxListpackSize is the fill of new lists, set by
+// list-max-listpack-size: a number of elements per node when positive, or
+// a node size class from -1 (4 KB) to -5 (64 KB).
+var listMaxListpackSize atomic.Int32
+
+func init() {
+	hashMaxListpackEntries.Store(128)
+	hashMaxListpackValue.Store(64)
+	listMaxListpackSize.Store(-2)
+}
+
+// stringEncoding is the encoding of a freshly written string: int when it
+// is the canonical form of a 64-bit integer, embstr when it is short, and
+// raw otherwise.
+func stringEncoding(s string) string {
+	if len(s) <= 20 {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+			return encodingInt
+		}
+	}
+	if len(s) <= embstrSizeLimit {
+		return encodingEmbstr
+	}
+	return encodingRaw
+}
+
+// hashFitsListpack reports whether a hash of n fields may hold field and
+// value and stay listpack encoded.
+func hashFitsListpack(n int, field, value string) bool {
+	limit := int(hashMaxListpackValue.Load())
+	return int64(n) <= hashMaxListpackEntries.Load() && len(field) <= limit && len(value) <= limit
+}
+
+func hashEncoding(hash map[string]string) string {
+	for field, value := range hash {
+		if !hashFitsListpack(len(hash), field, value) {
+			return encodingHashtable
+		}
+	}
+	return encodingListpack
+}
+
+// listEncoding reports a list that fits in a single node as listpack, and
+// one spread over several as quicklist.
+func listEncoding(l *lists.List) string {
+	if l.Nodes() <= 1 {
+		return encodingListpack
+	}
+	return encodingQuicklist
+}
+
+// valueEncoding is the encoding of a value stored by setKey without one.
+func valueEncoding(value RedisMapValue) string {
+	switch value.Keytype {
+	case "hash":
+		return hashEncoding(value.Hash)
+	case "list":
+		return listEncoding(value.List)
+	case "stream":
+		return encodingStream
+	}
+	return stringEncoding(value.Val)
+}
+
+// setEncoding changes the encoding recorded for key.
+func (db *DB) setEncoding(key, encoding string) {
+	sh := db.shard(key)
+	value := sh.dict[key]
+	value.Encoding = encoding
+	sh.dict[key] = value
+}
+
+// memoryUsage estimates the memory used by key and its value for MEMORY
+// USAGE. As in Redis, a collection with more than samples elements is
+// extrapolated from the first samples of them; 0 samples measures it all.
+func memoryUsage(key string, value RedisMapValue, samples int) int64 {
+	size := value.Size
+	switch value.Keytype {
+	case "hash":
+		if samples > 0 && len(value.Hash) > samples {
+			var sampled int64
+			n := 0
+			for field, v := range value.Hash {
+				if n == samples {
+					break
+				}
+				sampled += hashFieldSize(field, v)
+				n++
+			}
+			size = hashOverhead + sampled*int64(len(value.Hash))/int64(samples)
+		}
+	case "stream":
+		if samples > 0 && value.Stream.Len() > samples {
+			var sampled int64
+			entry := value.Stream.Head
+			for n := 0; n < samples; n++ {
+				sampled += streamEntrySize(entry.ID, entry.Value)
+				entry = entry.Next
+			}
+			size = streamOverhead + sampled*int64(value.Stream.Len())/int64(samples)
+		}
+	case "list":
+		if n := value.List.Len(); samples > 0 && n > samples {
+			var sampled int64
+			value.List.Range(0, samples-1, func(v string) {
+				sampled += listEntryOverhead + int64(len(v))
+			})
+			size = listOverhead + int64(value.List.Nodes())*listNodeOverhead + sampled*int64(n)/int64(samples)
+		}
+	}
+	return keyEntryOverhead + int64(len(key)) + size
+}
+
+// peakMemory is the highest usedMemory seen since startup.
+var peakMemory atomic.Int64
+
+// addUsedMemory accounts for delta bytes added to the keyspace.
+func addUsedMemory(delta int64) {
+	used := usedMemory.Add(delta)
+	for {
+		peak := peakMemory.Load()
+		if used <= peak || peakMemory.CompareAndSwap(peak, used) {
+			return
+		}
+	}
+}
+
+// memoryStats is the reply to MEMORY STATS. Callers hold keyspaceMu
+// exclusively.
+func memoryStats() Value {
+	used, peak := usedMemory.Load(), peakMemory.Load()
+	var keys, overhead int64
+	reply := []Value{
+		Bulk("peak.allocated"), Int(peak),
+		Bulk("total.allocated"), Int(used),
+	}
+	for _, db := range dbs {
+		var n int64
+		for _, sh := range db.shards {
+			n += int64(len(sh.dict))
+		}
+		if n == 0 {
+			continue
+		}
+		keys += n
+		overhead += n * keyEntryOverhead
+		reply = append(reply, Bulk("db."+strconv.Itoa(db.id)), Map(
+			Bulk("overhead.hashtable.main"), Int(n*keyEntryOverhead),
+		))
+	}
+	dataset := used - overhead
+	reply = append(reply,
+		Bulk("overhead.total"), Int(overhead),
+		Bulk("keys.count"), Int(keys),
+		Bulk("keys.bytes-per-key"), Int(perKey(used, keys)),
+		Bulk("dataset.bytes"), Int(dataset),
+		Bulk("dataset.percentage"), Double(percentage(dataset, used)),
+		Bulk("peak.percentage"), Double(percentage(used, peak)),
+	)
+	return Map(reply...)
+}
+
+func perKey(used, keys int64) int64 {
+	if keys == 0 {
+		return 0
+	}
+	return used / keys
+}
+
+func percentage(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(whole)
+}
+
+// memoryDoctorMinUsed is the usage below which MEMORY DOCTOR has too little
+// to look at, as in Redis.
+const memoryDoctorMinUsed = 5 << 20
+
+// memoryDoctor is the report of MEMORY DOCTOR, modelled on Redis' but
+// limited to what this server measures: there is no allocator to report
+// fragmentation, so only the dataset and its peak are checked.
+func memoryDoctor() string {
+	used, peak := usedMemory.Load(), peakMemory.Load()
+	if used < memoryDoctorMinUsed {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+	issues := ""
+	if float64(peak)/float64(used) > 1.5 {
+		issues += " * Peak memory: In the past this instance used more than 150% the memory that is currently using. The Go runtime returns memory to the system lazily after such a peak, so the process may stay larger than expected for a while; the memory will be reused as the dataset grows again.\n\n"
+	}
+	if limit := maxmemory.Load(); limit > 0 && used*10 > limit*9 && maxmemoryPolicy.Load() == policyNoeviction {
+		issues += " * Maxmemory: The dataset uses more than 90% of maxmemory and maxmemory-policy is noeviction, so writes will soon be refused with OOM errors. Consider raising maxmemory or choosing an eviction policy.\n\n"
+	}
+	if issues == "" {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+	return "Sam, I detected a few issues in this Redis instance memory implants:\n\n" + issues + "I'm here to keep you safe, Sam. I want to help you.\n"
+}
@@ -0,0 +1,137 @@
+package util
+
+import (
+	"math/bits"
+	"slices"
+)
+
+// scanTable indexes the keys of a database by hash bucket so that SCAN can
+// walk them with a cursor. Go maps offer no stable iteration position, so
+// the buckets are kept alongside the dictionary and iterated with Redis'
+// reverse binary cursor: every key present for the whole iteration is
+// returned at least once, even if the table grows between calls.
+type scanTable struct {
+	buckets [][]string
+	count   int
+}
+
+const scanTableMinSize = 16
+
+func newScanTable() *scanTable {
+	return &scanTable{buckets: make([][]string, scanTableMinSize)}
+}
+
+// hashKey is FNV-1a, computed inline to avoid allocating a hash.Hash for
+// every key.
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (t *scanTable) bucket(key string) int {
+	return int(hashKey(key) & uint64(len(t.buckets)-1))
+}
+
+// add indexes a key that is not in the table yet.
+func (t *scanTable) add(key string) {
+	if t.count >= len(t.buckets)*4 {
+		t.grow()
+	}
+	b := t.bucket(key)
+	t.buckets[b] = append(t.buckets[b], key)
+	t.count++
+}
+
+func (t *scanTable) remove(key string) {
+	b := t.bucket(key)
+	bucket := t.buckets[b]
+	for i, k := range bucket {
+		if k == key {
+			bucket[i] = bucket[len(bucket)-1]
+			t.buckets[b] = bucket[:len(bucket)-1]
+			t.count--
+			return
+		}
+	}
+}
+
+// copy returns a table indexing the same keys as t, for COPY.
+func (t *scanTable) copy() *scanTable {
+	buckets := make([][]string, len(t.buckets))
+	for i, bucket := range t.buckets {
+		buckets[i] = slices.Clone(bucket)
+	}
+	return &scanTable{buckets: buckets, count: t.count}
+}
+
+// grow doubles the number of buckets. The table never shrinks, which keeps
+// the cursor guarantees simple; FLUSHDB replaces it altogether.
+func (t *scanTable) grow() {
+	old := t.buckets
+	t.buckets = make([][]string, len(old)*2)
+	for _, bucket := range old {
+		for _, key := range bucket {
+			b := t.bucket(key)
+			t.buckets[b] = append(t.buckets[b], key)
+		}
+	}
+}
+
+// scan calls fn for the keys of the buckets starting at cursor until at
+// least count keys were visited or the table is exhausted, and returns the
+// cursor to continue from, 0 once the iteration is complete.
+func (t *scanTable) scan(cursor uint64, count int, fn func(key string)) uint64 {
+	mask := uint64(len(t.buckets) - 1)
+	visited := 0
+	for {
+		for _, key := range t.buckets[cursor&mask] {
+			fn(key)
+			visited++
+		}
+		// Increment the reversed cursor, so that the high bits change
+		// first and buckets split by a resize are never skipped.
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor == 0 || visited >= count {
+			return cursor
+		}
+	}
+}
+
+// scan walks the shards of db in order, each with its own bucket cursor. The
+// returned cursor carries the shard in its low shardBits bits and the bucket
+// cursor of that shard above them. A shard is only locked, through lock,
+// while it is visited. fn is called with that lock held once the buckets
+// were walked, so it may expire the key it is given.
+func (db *DB) scan(cursor uint64, count int, lock func(index int) func(), fn func(key string)) uint64 {
+	index := int(cursor & (shardCount - 1))
+	cursor >>= shardBits
+	visited := 0
+	var keys []string
+	for {
+		unlock := lock(index)
+		keys = keys[:0]
+		cursor = db.shards[index].keys.scan(cursor, count-visited, func(key string) {
+			keys = append(keys, key)
+		})
+		for _, key := range keys {
+			fn(key)
+		}
+		unlock()
+		visited += len(keys)
+		if cursor != 0 {
+			return cursor<<shardBits | uint64(index)
+		}
+		index++
+		if index == shardCount {
+			return 0
+		}
+		if visited >= count {
+			return uint64(index)
+		}
+	}
+}
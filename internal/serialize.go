@@ -1,96 +1,340 @@
 package util
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
 	"io"
+	"math"
 	"strconv"
+	"strings"
+	"sync"
 )
 
+var bufioPool = sync.Pool{
+	New: func() any { return bufio.NewWriterSize(nil, 16*1024) },
+}
+
+// Writer encodes replies straight into a pooled buffered writer, so no
+// intermediate []byte is built per reply. Callers must Flush once they have
+// no more pipelined requests to answer, and Release the writer when the
+// connection is done with it.
 type Writer struct {
-	writer io.Writer
+	writer  *bufio.Writer
+	Proto   int
+	scratch [32]byte
 }
 
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{writer: w}
+	bw := bufioPool.Get().(*bufio.Writer)
+	bw.Reset(w)
+	return &Writer{writer: bw, Proto: 2}
+}
+
+func (w *Writer) Flush() error {
+	return w.writer.Flush()
+}
+
+// Release returns the writer's buffer to the pool. The Writer must not be
+// used afterwards.
+func (w *Writer) Release() {
+	w.writer.Reset(nil)
+	bufioPool.Put(w.writer)
+	w.writer = nil
 }
 
+// Write encodes v for the writer's protocol version.
 func (w *Writer) Write(v Value) error {
-	var bytes = v.Marshall()
-	_, err := w.writer.Write(bytes)
-	if err != nil {
+	switch v.Type {
+	case KindArray:
+		return w.writeAggregate(ARRAY, len(v.Array), v.Array)
+	case KindBulk:
+		return w.WriteBulk(v.Bulk)
+	case KindString:
+		return w.WriteSimpleString(v.Str)
+	case KindNullBulk:
+		return w.WriteNullBulk()
+	case KindNullArray:
+		return w.WriteNullArray()
+	case KindError:
+		return w.WriteError(v.Str)
+	case KindInteger:
+		return w.WriteInt(v.Int)
+	case KindMap:
+		// RESP2 clients receive the interleaved pairs as a flat array.
+		if w.Proto < 3 {
+			return w.writeAggregate(ARRAY, len(v.Array), v.Array)
+		}
+		return w.writeAggregate(MAP, len(v.Array)/2, v.Array)
+	case KindSet:
+		if w.Proto < 3 {
+			return w.writeAggregate(ARRAY, len(v.Array), v.Array)
+		}
+		return w.writeAggregate(SET, len(v.Array), v.Array)
+	case KindDouble:
+		return w.WriteDouble(v.Float)
+	case KindBoolean:
+		return w.WriteBool(v.Bool)
+	case KindVerbatim:
+		return w.WriteVerbatim(v.Str, v.Bulk)
+	case KindBignum:
+		if w.Proto < 3 {
+			return w.WriteBulk(v.Str)
+		}
+		return w.writeLine(BIGNUM, v.Str)
+	case KindBulkError:
+		if w.Proto < 3 {
+			return w.WriteError(v.Bulk)
+		}
+		return w.writeBlob(BULKERROR, v.Bulk)
+	case KindStream:
+		return w.writeStream(v)
+	case KindSent:
+		return nil
+	case KindRaw:
+		_, err := w.writer.WriteString(v.Bulk)
 		return err
+	default:
+		// Never put a malformed frame on the wire, even for a Value that was
+		// built without one of the constructors.
+		return w.WriteError("ERR internal error: cannot encode " + v.Type.String() + " reply")
+	}
+}
+
+func (w *Writer) writeAggregate(prefix byte, count int, elems []Value) error {
+	if err := w.writeHeader(prefix, int64(count)); err != nil {
+		return err
+	}
+	for _, elem := range elems {
+		if err := w.Write(elem); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (v Value) Marshall() []byte {
-	switch v.Type {
-	case "array":
-		return v.marshallArray()
-	case "bulk":
-		return v.marshallBulk()
-	case "string":
-		return v.marshallString()
-	case "null":
-		return v.marshallNull()
-	case "error":
-		return v.marshallError()
-	case "integer":
-		return v.marshallInteger()
-	default:
-		return []byte{}
+func (w *Writer) writeHeader(prefix byte, n int64) error {
+	b := append(w.scratch[:0], prefix)
+	b = strconv.AppendInt(b, n, 10)
+	b = append(b, CARRIAGERETURN, LINEFEED)
+	_, err := w.writer.Write(b)
+	return err
+}
+
+func (w *Writer) writeLine(prefix byte, s string) error {
+	w.writer.WriteByte(prefix)
+	w.writer.WriteString(s)
+	_, err := w.writer.Write(crlf)
+	return err
+}
+
+func (w *Writer) writeBlob(prefix byte, s string) error {
+	if err := w.writeHeader(prefix, int64(len(s))); err != nil {
+		return err
 	}
+	w.writer.WriteString(s)
+	_, err := w.writer.Write(crlf)
+	return err
+}
+
+var crlf = []byte{CARRIAGERETURN, LINEFEED}
+
+// lineSafe strips CR and LF, which would otherwise terminate a simple string
+// or error frame early and desynchronise the client.
+func lineSafe(s string) string {
+	if !strings.ContainsAny(s, "\r\n") {
+		return s
+	}
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+func (w *Writer) WriteSimpleString(s string) error {
+	return w.writeLine(STRING, lineSafe(s))
+}
+
+func (w *Writer) WriteError(msg string) error {
+	return w.writeLine(ERROR, lineSafe(msg))
+}
+
+func (w *Writer) WriteInt(n int64) error {
+	return w.writeHeader(INTEGER, n)
 }
 
-func (v *Value) marshallInteger() []byte {
-	var bytes []byte
-	bytes = append(bytes, INTEGER)
-	bytes = append(bytes, v.Str...)
-	bytes = append(bytes, CARRIAGERETURN, LINEFEED)
-	return bytes
+func (w *Writer) WriteBulk(s string) error {
+	return w.writeBlob(BULK, s)
 }
 
-func (v *Value) marshallString() []byte {
-	var bytes []byte
-	bytes = append(bytes, STRING)
-	bytes = append(bytes, v.Str...)
-	if v.Bulk != "" {
-		return bytes
+func (w *Writer) WriteNullBulk() error {
+	if w.Proto >= 3 {
+		_, err := w.writer.WriteString("_\r\n")
+		return err
 	}
-	bytes = append(bytes, CARRIAGERETURN, LINEFEED)
-	return bytes
+	_, err := w.writer.WriteString("$-1\r\n")
+	return err
 }
 
-func (v *Value) marshallBulk() []byte {
-	var bytes []byte
-	bytes = append(bytes, BULK)
-	bytes = append(bytes, strconv.Itoa(v.Num)...)
-	bytes = append(bytes, CARRIAGERETURN, LINEFEED)
-	bytes = append(bytes, v.Bulk...)
-	bytes = append(bytes, CARRIAGERETURN, LINEFEED)
-	return bytes
+func (w *Writer) WriteNullArray() error {
+	if w.Proto >= 3 {
+		_, err := w.writer.WriteString("_\r\n")
+		return err
+	}
+	_, err := w.writer.WriteString("*-1\r\n")
+	return err
 }
 
-func (v *Value) marshallArray() []byte {
-	len := v.Num
-	var bytes []byte
+// WriteArrayHeader starts an array of n elements; the caller then writes
+// exactly n values.
+func (w *Writer) WriteArrayHeader(n int) error {
+	return w.writeHeader(ARRAY, int64(n))
+}
 
-	bytes = append(bytes, ARRAY)
-	bytes = append(bytes, strconv.Itoa(len)...)
-	bytes = append(bytes, CARRIAGERETURN, LINEFEED)
-	for i := 0; i < len; i++ {
-		bytes = append(bytes, v.Array[i].Marshall()...)
+// WriteMapHeader starts a map of n pairs, which RESP2 clients receive as a
+// flat array of 2n elements.
+func (w *Writer) WriteMapHeader(n int) error {
+	if w.Proto < 3 {
+		return w.writeHeader(ARRAY, int64(n)*2)
 	}
-	return bytes
+	return w.writeHeader(MAP, int64(n))
 }
 
-func (v *Value) marshallError() []byte {
-	var bytes []byte
-	bytes = append(bytes, ERROR)
-	bytes = append(bytes, v.Str...)
-	bytes = append(bytes, CARRIAGERETURN, LINEFEED)
-	return bytes
+// formatDouble renders f the way Redis does: the shortest representation
+// that round-trips, with inf, -inf and nan spelled out.
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (w *Writer) WriteDouble(f float64) error {
+	if w.Proto < 3 {
+		return w.WriteBulk(formatDouble(f))
+	}
+	return w.writeLine(DOUBLE, formatDouble(f))
+}
+
+// WriteBool sends RESP2 clients 1 or 0.
+func (w *Writer) WriteBool(b bool) error {
+	if w.Proto < 3 {
+		if b {
+			return w.WriteInt(1)
+		}
+		return w.WriteInt(0)
+	}
+	if b {
+		return w.writeLine(BOOLEAN, "t")
+	}
+	return w.writeLine(BOOLEAN, "f")
+}
+
+// WriteVerbatim expects format to be three letters, such as "txt".
+func (w *Writer) WriteVerbatim(format, s string) error {
+	if w.Proto < 3 {
+		return w.WriteBulk(s)
+	}
+	if err := w.writeHeader(VERBATIM, int64(len(format)+1+len(s))); err != nil {
+		return err
+	}
+	w.writer.WriteString(format)
+	w.writer.WriteByte(':')
+	w.writer.WriteString(s)
+	_, err := w.writer.Write(crlf)
+	return err
+}
+
+// ArrayStream receives the elements of a KindStream array from the
+// function producing them.
+type ArrayStream struct {
+	add   func(v Value) error
+	n     int
+	count int
+	err   error
+}
+
+// Add appends one element to the array. Elements past the length the
+// array was announced with are dropped, and reported by the writer.
+func (a *ArrayStream) Add(v Value) {
+	a.count++
+	if a.err != nil || a.count > a.n {
+		return
+	}
+	a.err = a.add(v)
+}
+
+// produce runs the function of the stream array v, handing each element
+// to add, and reports whether exactly the announced number was produced.
+func produce(v Value, add func(v Value) error) error {
+	a := &ArrayStream{add: add, n: int(v.Int)}
+	v.Stream(a)
+	if a.err != nil {
+		return a.err
+	}
+	if a.count != a.n {
+		return fmt.Errorf("stream array of %d elements produced %d", a.n, a.count)
+	}
+	return nil
+}
+
+// writeStream writes the header of a KindStream array, then its elements
+// straight into the buffered writer as they are produced.
+func (w *Writer) writeStream(v Value) error {
+	if err := w.WriteArrayHeader(int(v.Int)); err != nil {
+		return err
+	}
+	return produce(v, w.Write)
+}
+
+// hasStream reports whether v holds a KindStream array at any depth.
+func (v Value) hasStream() bool {
+	if v.Type == KindStream {
+		return true
+	}
+	for _, elem := range v.Array {
+		if elem.hasStream() {
+			return true
+		}
+	}
+	return false
+}
+
+// collect returns v with its stream arrays turned into ordinary ones, for
+// a client that has no connection to write them to.
+func (v Value) collect() Value {
+	if v.Type == KindStream {
+		elems := make([]Value, 0, v.Int)
+		produce(v, func(elem Value) error {
+			elems = append(elems, elem.collect())
+			return nil
+		})
+		return Array(elems...)
+	}
+	if v.hasStream() {
+		elems := make([]Value, len(v.Array))
+		for i, elem := range v.Array {
+			elems[i] = elem.collect()
+		}
+		v.Array = elems
+	}
+	return v
+}
+
+// Marshall encodes v as RESP2, downgrading RESP3-only types.
+func (v Value) Marshall() []byte {
+	return v.MarshallProto(2)
 }
 
-func (v *Value) marshallNull() []byte {
-	return []byte("$-1\r\n")
+// MarshallProto encodes v for a connection speaking the given protocol version.
+func (v Value) MarshallProto(proto int) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Proto = proto
+	w.Write(v)
+	w.Flush()
+	w.Release()
+	return buf.Bytes()
 }
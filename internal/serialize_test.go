@@ -0,0 +1,59 @@
+package util
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestStreamArray(t *testing.T) {
+	for _, proto := range []int{2, 3} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.Proto = proto
+		w.Write(StreamArray(2, func(a *ArrayStream) {
+			a.Add(Bulk("a"))
+			a.Add(Int(1))
+		}))
+		w.Flush()
+		if got, want := buf.String(), "*2\r\n$1\r\na\r\n:1\r\n"; got != want {
+			t.Errorf("RESP%d: got %q, want %q", proto, got, want)
+		}
+	}
+	for _, n := range []int{1, 3} {
+		w := NewWriter(&bytes.Buffer{})
+		err := w.Write(StreamArray(n, func(a *ArrayStream) {
+			a.Add(Bulk("a"))
+			a.Add(Bulk("b"))
+		}))
+		if err == nil {
+			t.Errorf("array announced with %d elements produced 2 without error", n)
+		}
+	}
+}
+
+func TestCallWritesStreamedReplies(t *testing.T) {
+	InitDatabases(16)
+	c := newTestClient()
+	for i := range 3 {
+		call(c, "RPUSH", "l", strconv.Itoa(i))
+	}
+	if reply := call(c, "LRANGE", "l", "0", "-1"); reply.Type != KindArray || len(reply.Array) != 3 {
+		t.Fatalf("LRANGE without a connection: got %v", reply)
+	}
+	var buf bytes.Buffer
+	c.Writer = NewWriter(&buf)
+	reply := call(c, "MULTI")
+	c.Reply(reply)
+	c.Reply(call(c, "LRANGE", "l", "1", "-1"))
+	reply = call(c, "EXEC")
+	if reply.Type != KindSent {
+		t.Fatalf("EXEC with a streamed reply: got %v, want it sent", reply)
+	}
+	c.Reply(reply)
+	c.Flush()
+	want := "+OK\r\n+QUEUED\r\n*1\r\n*2\r\n$1\r\n1\r\n$1\r\n2\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
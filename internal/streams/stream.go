@@ -60,17 +60,26 @@ func (s *Stream) DeleteEntry(id string) {
 
 func (s *Stream) RangeQuery(startID, endID string) []*StreamEntry {
 	var result []*StreamEntry
+	s.RangeEach(startID, endID, func(entry *StreamEntry) {
+		result = append(result, entry)
+	})
+	return result
+}
+
+// RangeEach calls fn for every entry between startID and endID inclusive,
+// without collecting them first.
+func (s *Stream) RangeEach(startID, endID string, fn func(*StreamEntry)) {
 	if startID == "-" {
 		startID = string(rune(33))
-	} else if endID == "+" {
+	}
+	if endID == "+" {
 		endID = string(rune(1114111))
 	}
 	for entry := s.Head; entry != nil; entry = entry.Next {
 		if entry.ID >= startID && entry.ID <= endID {
-			result = append(result, entry)
+			fn(entry)
 		}
 	}
-	return result
 }
 
 func (s *Stream) QueryXread(startID string) []*StreamEntry {
@@ -82,3 +91,30 @@ func (s *Stream) QueryXread(startID string) []*StreamEntry {
 	}
 	return result
 }
+
+// Copy returns a deep copy of s. Clients blocked on s are not carried over.
+func (s *Stream) Copy() *Stream {
+	dup := NewStream()
+	dup.LastTime = s.LastTime
+	dup.LastSeq = s.LastSeq
+	for entry := s.Head; entry != nil; entry = entry.Next {
+		value := make(map[string]string, len(entry.Value))
+		for k, v := range entry.Value {
+			value[k] = v
+		}
+		copied := &StreamEntry{ID: entry.ID, Value: value, Prev: dup.Tail}
+		if dup.Tail == nil {
+			dup.Head = copied
+		} else {
+			dup.Tail.Next = copied
+		}
+		dup.Tail = copied
+		dup.Entries[entry.ID] = copied
+	}
+	return dup
+}
+
+// Len returns the number of entries in s.
+func (s *Stream) Len() int {
+	return len(s.Entries)
+}
@@ -0,0 +1,171 @@
+package util
+
+import "fmt"
+
+// Kind identifies which RESP frame a Value encodes.
+type Kind uint8
+
+const (
+	// KindInvalid is the zero Kind, so that an uninitialised Value is never
+	// mistaken for a well-formed reply.
+	KindInvalid Kind = iota
+	KindString
+	KindError
+	KindInteger
+	KindBulk
+	KindNullBulk
+	KindArray
+	KindNullArray
+	KindMap
+	KindSet
+	KindDouble
+	KindBoolean
+	KindVerbatim
+	KindBignum
+	KindBulkError
+	// KindStream is an array whose elements are produced while the reply is
+	// being written, see StreamArray.
+	KindStream
+	// KindRaw carries pre-encoded bytes that are written to the wire as is,
+	// such as the RDB payload following FULLRESYNC.
+	KindRaw
+	// KindSent stands for a reply that was already written to the client,
+	// and is skipped by writers.
+	KindSent
+)
+
+var kindNames = [...]string{
+	KindInvalid:   "invalid",
+	KindString:    "string",
+	KindError:     "error",
+	KindInteger:   "integer",
+	KindBulk:      "bulk",
+	KindNullBulk:  "null",
+	KindArray:     "array",
+	KindNullArray: "nullarray",
+	KindMap:       "map",
+	KindSet:       "set",
+	KindDouble:    "double",
+	KindBoolean:   "boolean",
+	KindVerbatim:  "verbatim",
+	KindBignum:    "bignum",
+	KindBulkError: "bulkerror",
+	KindStream:    "stream",
+	KindRaw:       "raw",
+	KindSent:      "sent",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", uint8(k))
+}
+
+// Value is a single RESP frame. Bulk may hold arbitrary binary data; the
+// length written on the wire is always taken from len(Bulk) or len(Array).
+// Values should be built with the constructors below rather than literals.
+type Value struct {
+	Type  Kind
+	Str   string  // Simple String, Error, Bignum, or the format of a Verbatim string
+	Int   int64   // Integer
+	Float float64 // Double
+	Bool  bool    // Boolean
+	Bulk  string  // Bulk String, Verbatim string or Bulk Error payload
+	Array []Value // Array, Set, or Map as interleaved keys and values
+
+	Stream func(a *ArrayStream) // Produces the Int elements of a KindStream array
+}
+
+func SimpleString(s string) Value {
+	return Value{Type: KindString, Str: s}
+}
+
+func OK() Value {
+	return SimpleString("OK")
+}
+
+func Error(msg string) Value {
+	return Value{Type: KindError, Str: msg}
+}
+
+func Errorf(format string, args ...any) Value {
+	return Error(fmt.Sprintf(format, args...))
+}
+
+func Int(n int64) Value {
+	return Value{Type: KindInteger, Int: n}
+}
+
+func Bulk(s string) Value {
+	return Value{Type: KindBulk, Bulk: s}
+}
+
+func NullBulk() Value {
+	return Value{Type: KindNullBulk}
+}
+
+func Array(elems ...Value) Value {
+	if elems == nil {
+		elems = []Value{}
+	}
+	return Value{Type: KindArray, Array: elems}
+}
+
+// StreamArray returns an array of n elements that fn adds while the reply
+// is written, each going straight to the client, so large replies are never
+// materialised as a []Value. Call writes the reply before it releases the
+// command's locks, so fn may read the keys the handler locked.
+func StreamArray(n int, fn func(a *ArrayStream)) Value {
+	return Value{Type: KindStream, Int: int64(n), Stream: fn}
+}
+
+func NullArray() Value {
+	return Value{Type: KindNullArray}
+}
+
+// BulkArray builds an array of bulk strings.
+func BulkArray(elems ...string) Value {
+	arr := make([]Value, len(elems))
+	for i, e := range elems {
+		arr[i] = Bulk(e)
+	}
+	return Array(arr...)
+}
+
+// Map builds a map from interleaved keys and values; RESP2 clients receive
+// it as a flat array.
+func Map(pairs ...Value) Value {
+	if pairs == nil {
+		pairs = []Value{}
+	}
+	return Value{Type: KindMap, Array: pairs}
+}
+
+func Set(elems ...Value) Value {
+	if elems == nil {
+		elems = []Value{}
+	}
+	return Value{Type: KindSet, Array: elems}
+}
+
+func Double(f float64) Value {
+	return Value{Type: KindDouble, Float: f}
+}
+
+func Bool(b bool) Value {
+	return Value{Type: KindBoolean, Bool: b}
+}
+
+func Verbatim(format, s string) Value {
+	return Value{Type: KindVerbatim, Str: format, Bulk: s}
+}
+
+func Raw(b []byte) Value {
+	return Value{Type: KindRaw, Bulk: string(b)}
+}
+
+// IsError reports whether v is an error reply of either RESP flavour.
+func (v Value) IsError() bool {
+	return v.Type == KindError || v.Type == KindBulkError
+}